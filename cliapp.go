@@ -2,30 +2,95 @@
 package cliapp
 
 import (
+	"bufio"
+	"context"
+	"encoding"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"maps"
+	"math"
 	"os"
+	"path/filepath"
 	"reflect"
+	"runtime/debug"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
+	"text/template"
+	"time"
 	"unicode"
+	"unicode/utf8"
 )
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+var (
+	flagValueType       = reflect.TypeOf((*flag.Value)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// overridable in tests
+var readBuildInfo = debug.ReadBuildInfo
+
 type handler struct {
 	fn           reflect.Value
-	targs        []reflect.Type
+	targs        []reflect.Type // parsed parameters, excluding a leading context.Context
+	hasContext   bool
+	variadic     bool // last parameter is variadic and takes all remaining args
 	expectsError bool
 	help         string
+	tokens       []string
+	meta         map[string]string
+	alias        string // canonical command name when registered via Alias
+	hidden       bool   // left out of help and completion
 }
 
 // Represents a small command-line application runtime.
 type App struct {
-	cmds map[string]handler
-	root *handler
-	opts *Options
+	cmds     map[string]handler
+	root     *handler
+	name     string
+	opts     *Options
+	onParsed []func(command string, v reflect.Value) error
+	before   []func(command string, args []string) error
+	// value completion functions by command name, then long option name
+	completers map[string]map[string]func(prefix string) []string
+	globals    reflect.Value // pointer to the struct registered with GlobalFlags
+	after      []func(command string, err error)
+	input      *bufio.Reader
 }
 
+// Selects what Run does when it is called without arguments.
+type NoArgsBehavior int
+
+const (
+	// show the root command's help, or the global help (default)
+	NoArgsShowHelp NoArgsBehavior = iota
+	// report a usage error
+	NoArgsError
+	// run the root command with no arguments; shows help if there is none
+	NoArgsRunRoot
+)
+
+// Selects how untagged field names become long option names.
+type FlagCase int
+
+const (
+	// OutDir -> --out-dir (default)
+	FlagCaseKebab FlagCase = iota
+	// OutDir -> --out_dir
+	FlagCaseSnake
+	// OutDir -> --outDir
+	FlagCaseCamel
+	// OutDir -> --outdir
+	FlagCaseLower
+)
+
 // Configures runtime behavior for an App instance.
 type Options struct {
 	// when true the process will exit with code 1 on command
@@ -36,6 +101,110 @@ type Options struct {
 
 	// writer used for error messages. (default is os.Stderr)
 	LogError io.Writer
+
+	// writer used for warnings such as deprecated option use. (default is LogError)
+	LogWarning io.Writer
+
+	// function called with the exit code when ExitOnError is true. (default is os.Exit)
+	Exit func(code int)
+
+	// when true, the help of a command whose arguments fail to parse is
+	// written to LogError, ahead of the error when ExitOnError is set
+	ShowUsageOnError bool
+
+	// when true, arguments left over after parsing a struct handler are an error
+	StrictArgs bool
+
+	// when true, unknown options are not an error: a struct handler collects
+	// them into its `rest` field, ahead of the leftover args, and drops them
+	// when it has none
+	IgnoreUnknownOptions bool
+
+	// when true, a panic in a handler is recovered and reported as a
+	// *PanicError instead of crashing the process
+	RecoverPanics bool
+
+	// when true, a long option may be abbreviated to any unambiguous prefix,
+	// e.g. --verb for --verbose
+	AllowAbbrev bool
+
+	// options that show help. (default is -h and --help) A non-nil empty
+	// list disables them, leaving only the help command.
+	HelpFlags []string
+
+	// the command word that shows help. (default is "help") "-" disables it.
+	// A registered command of the same name takes precedence.
+	HelpCommand string
+
+	// how long option names are generated from field names. (default is FlagCaseKebab)
+	FlagCase FlagCase
+
+	// when true, short option names may be longer than one character (e.g. -vv)
+	MultiCharShort bool
+
+	// when true, Add panics if a struct parameter has a field without an
+	// arg, long, short, flag or rest tag, instead of exposing it as an
+	// implicit kebab-case long option
+	RequireExplicitTags bool
+
+	// what to do when Run is called without arguments. (default is NoArgsShowHelp)
+	NoArgsBehavior NoArgsBehavior
+
+	// when true, Run without arguments invokes the root handler with an
+	// empty argument list instead of printing its help. Same as setting
+	// NoArgsBehavior to NoArgsRunRoot.
+	RunRootOnEmpty bool
+
+	// format of errors written to LogError. (default is ErrorFormatText)
+	ErrorFormat ErrorFormat
+
+	// config file whose values fill options not given on the command line or
+	// through the environment, ahead of `default` tags. An option tagged
+	// `configfile` names another file. Ignored when the file does not exist.
+	ConfigFile string
+
+	// function decoding a config file into a map[string]any, e.g. a YAML
+	// decoder. (default decodes JSON)
+	ConfigDecoder func(r io.Reader, v any) error
+
+	// function used to read environment variables. (default is os.LookupEnv)
+	LookupEnv func(key string) (string, bool)
+
+	// reader used for interactive input, @- values and "-" values of fields
+	// tagged `stdin`. (default is os.Stdin)
+	Input io.Reader
+
+	// when true and Input is a terminal, missing required values are
+	// prompted for instead of reported as errors
+	InteractiveMissing bool
+
+	// width help output is wrapped to; long option descriptions continue on
+	// indented lines. (default is $COLUMNS, or no wrapping when unset)
+	HelpWidth int
+
+	// when true, command names match regardless of case, so `Status` runs
+	// the "status" command. Option names stay case-sensitive.
+	CaseInsensitive bool
+
+	// when true, help headings, command and option names are colored, and
+	// errors written to LogError are shown in red. Color is only used when
+	// the writer is a terminal and the NO_COLOR environment variable is unset.
+	Color bool
+
+	// when true, values of fields tagged `choices` match case-insensitively
+	// and are stored as spelled in the tag
+	ChoicesIgnoreCase bool
+
+	// when set, replaces the built-in application and command help. The
+	// template is executed with a HelpData value.
+	HelpTemplate *template.Template
+
+	// version string printed by --version, -v and the version command.
+	Version string
+
+	// when true and Version is empty, --version prints the module version,
+	// Go version and VCS information embedded in the binary's build info.
+	BuildInfoVersion bool
 }
 
 // Create a new App with the default options.
@@ -51,6 +220,18 @@ func New(opts Options) *App {
 	if opts.LogError == nil {
 		opts.LogError = os.Stderr
 	}
+	if opts.LogWarning == nil {
+		opts.LogWarning = opts.LogError
+	}
+	if opts.Input == nil {
+		opts.Input = os.Stdin
+	}
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	if opts.Exit == nil {
+		opts.Exit = os.Exit
+	}
 	app := &App{cmds: make(map[string]handler), opts: &opts}
 	return app
 }
@@ -64,8 +245,258 @@ func New(opts Options) *App {
 //
 // Supported parameter types:
 //
-//	string, int, int64, float64, bool
+//	string, bool, time.Duration
+//	int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64
+//	float32, float64
+//	types implementing flag.Value or encoding.TextUnmarshaler
+//
+// Trailing pointer parameters are optional and a variadic parameter takes
+// the remaining arguments. Struct parameters map their fields to options
+// and positionals; fields may also be slices or maps of these types.
 func (a *App) Add(name string, rest ...any) {
+	a.AddTokens(strings.Fields(name), rest...)
+}
+
+// Add new command from an explicit list of name tokens.
+//
+// Each token is matched against exactly one argument, so tokens are never
+// re-split on whitespace. An empty list registers the root command.
+//
+//	AddTokens([]string{"db", "migrate"}, fn)
+//	AddTokens([]string{"db", "migrate"}, help, fn)
+func (a *App) AddTokens(tokens []string, rest ...any) {
+	a.add(tokens, nil, false, rest)
+}
+
+// Add new command whose handler takes an args struct of type T, which may
+// also be a pointer to a struct. The handler's signature is checked by the
+// compiler rather than when the command is added; otherwise it behaves like
+// Add.
+//
+//	cliapp.AddStruct(app, "copy", func(args CopyArgs) error { ... })
+func AddStruct[T any](app *App, name string, fn func(T) error) {
+	if _, ok := structParam(reflect.TypeFor[T]()); !ok {
+		panic(fmt.Sprintf("AddStruct requires a struct type, got %s", reflect.TypeFor[T]()))
+	}
+	app.Add(name, fn)
+}
+
+// Add new command that runs like any other but is left out of help, the
+// command list and completion scripts. Accepts the same arguments as Add.
+func (a *App) AddHidden(name string, rest ...any) {
+	a.add(strings.Fields(name), nil, true, rest)
+}
+
+// Add new command with metadata attached.
+//
+// The metadata is opaque to cliapp; it is kept for inspection through Meta,
+// e.g. to tag commands with categories or stability levels.
+//
+//	AddWithMeta(name string, meta map[string]string, fn func(...))
+//	AddWithMeta(name string, meta map[string]string, help string, fn func(...))
+func (a *App) AddWithMeta(name string, meta map[string]string, rest ...any) {
+	a.add(strings.Fields(name), meta, false, rest)
+}
+
+// Returns the metadata attached to a command ("" is the root command).
+func (a *App) Meta(name string) (map[string]string, bool) {
+	h, ok := a.lookup(name)
+	if !ok {
+		return nil, false
+	}
+	return maps.Clone(h.meta), true
+}
+
+// Returns the help text of the command registered under the name ("" is
+// the root command). An alias reports the help of its command.
+func (a *App) Lookup(name string) (string, bool) {
+	h, ok := a.lookup(name)
+	if !ok {
+		return "", false
+	}
+	return h.help, true
+}
+
+// Returns the command registered under the name ("" is the root command),
+// described like Commands does. An alias reports its command.
+func (a *App) Command(name string) (CommandInfo, bool) {
+	h, ok := a.lookup(name)
+	if !ok {
+		return CommandInfo{}, false
+	}
+	if h.alias != "" {
+		return a.commandInfo(h.alias, a.cmds[h.alias]), true
+	}
+	return a.commandInfo(strings.Join(h.tokens, " "), h), true
+}
+
+// Registers alias as another name for the command registered as name.
+//
+// The alias runs the same handler and shows the same help as the canonical
+// command, and is listed next to it in help rather than on its own row.
+func (a *App) Alias(alias string, name string) {
+	h, ok := a.lookup(name)
+	if !ok || name == "" {
+		panic(fmt.Sprintf("alias %q refers to unknown command %q", alias, name))
+	}
+	if h.alias != "" {
+		name = h.alias
+	}
+	tokens := strings.Fields(alias)
+	if len(tokens) == 0 {
+		panic("alias must not be empty")
+	}
+	h.tokens = tokens
+	h.alias = strings.Join(strings.Fields(name), " ")
+	a.register(h)
+}
+
+// Describes a registered command, as returned by Commands.
+type CommandInfo struct {
+	// space-separated command name; empty for the root command
+	Name string
+	Help string
+	// names registered for the command with Alias, sorted
+	Aliases []string
+	// parameter types of the handler, excluding a leading context.Context
+	Params []reflect.Type
+	// reports whether the handler takes a struct parameter parsed from
+	// options, rather than only positional arguments
+	Struct   bool
+	Variadic bool
+	// registered with AddHidden
+	Hidden bool
+	Meta   map[string]string
+}
+
+// Returns a snapshot of the registered commands sorted by name. Aliases are
+// reported on their command rather than as commands of their own.
+func (a *App) Commands() []CommandInfo {
+	var infos []CommandInfo
+	if a.root != nil {
+		infos = append(infos, a.commandInfo("", *a.root))
+	}
+	for name, h := range a.cmds {
+		if h.alias != "" {
+			continue
+		}
+		infos = append(infos, a.commandInfo(name, h))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+func (a *App) commandInfo(name string, h handler) CommandInfo {
+	info := CommandInfo{
+		Name:     name,
+		Help:     h.help,
+		Params:   slices.Clone(h.targs),
+		Variadic: h.variadic,
+		Hidden:   h.hidden,
+		Meta:     maps.Clone(h.meta),
+	}
+	if name != "" {
+		info.Aliases = a.aliasesOf(name)
+	}
+	for _, t := range h.targs {
+		if _, ok := structParam(t); ok {
+			info.Struct = true
+		}
+	}
+	return info
+}
+
+// Returns the registered command closest to the start of args, if it is
+// within two edits. Each command is compared with as many args as it has
+// tokens, so "remote ad" suggests "remote add".
+func (a *App) suggestCommand(args []string) (string, bool) {
+	names := make([]string, 0, len(a.cmds))
+	for name := range a.cmds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	best, bestDist := "", 3
+	for _, name := range names {
+		n := len(a.cmds[name].tokens)
+		if n > len(args) || a.cmds[name].hidden {
+			continue
+		}
+		d := levenshtein(strings.Join(args[:n], " "), name)
+		if d < bestDist && d < len(name) {
+			best, bestDist = name, d
+		}
+	}
+	return best, best != ""
+}
+
+// Returns the edit distance between s and t
+func levenshtein(s, t string) int {
+	a, b := []rune(s), []rune(t)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// Unregisters the command registered as name, or the root command when name
+// is empty, together with its aliases. Removing an alias only removes the
+// alias. Reports whether anything was removed.
+func (a *App) Remove(name string) bool {
+	if strings.TrimSpace(name) == "" {
+		removed := a.root != nil
+		a.root = nil
+		return removed
+	}
+
+	key := strings.Join(strings.Fields(name), " ")
+	if _, ok := a.cmds[key]; !ok {
+		return false
+	}
+	delete(a.cmds, key)
+	for _, alias := range a.aliasesOf(key) {
+		delete(a.cmds, alias)
+	}
+	return true
+}
+
+// Returns the sorted aliases registered for the command name.
+func (a *App) aliasesOf(name string) []string {
+	var aliases []string
+	for alias, h := range a.cmds {
+		if h.alias == name {
+			aliases = append(aliases, alias)
+		}
+	}
+	sort.Strings(aliases)
+	return aliases
+}
+
+func (a *App) lookup(name string) (handler, bool) {
+	if name == "" {
+		if a.root == nil {
+			return handler{}, false
+		}
+		return *a.root, true
+	}
+	h, ok := a.cmds[strings.Join(strings.Fields(name), " ")]
+	return h, ok
+}
+
+func (a *App) add(tokens []string, meta map[string]string, hidden bool, rest []any) {
 	var help string
 	var fn any
 	switch len(rest) {
@@ -88,10 +519,14 @@ func (a *App) Add(name string, rest ...any) {
 	}
 
 	ft := v.Type()
-	nargs := ft.NumIn()
-	targs := make([]reflect.Type, nargs)
-	for i := range nargs {
-		targs[i] = ft.In(i)
+	// a leading context.Context is supplied by RunContext, not parsed from argv
+	hasCtx := ft.NumIn() > 0 && ft.In(0) == contextType
+	targs := make([]reflect.Type, 0, ft.NumIn())
+	for i := range ft.NumIn() {
+		if i == 0 && hasCtx {
+			continue
+		}
+		targs = append(targs, ft.In(i))
 	}
 
 	expectsErr := false
@@ -100,91 +535,151 @@ func (a *App) Add(name string, rest ...any) {
 		expectsErr = true
 	}
 
-	h := handler{fn: v, targs: targs, expectsError: expectsErr, help: help}
-	if name == "" {
+	a.validateParams(strings.Join(tokens, " "), targs, ft.IsVariadic())
+	a.checkGlobalConflicts(strings.Join(tokens, " "), targs)
+
+	h := handler{fn: v, targs: targs, hasContext: hasCtx, variadic: ft.IsVariadic(), expectsError: expectsErr, help: help, tokens: tokens, meta: maps.Clone(meta), hidden: hidden}
+	if len(tokens) == 0 {
 		// register root command
 		a.root = &h
 		return
 	}
 
-	a.cmds[name] = h
+	a.register(h)
 }
 
-// Parses arguments and executes the matching command.
-func (a *App) Run(args ...string) error {
-	if args == nil {
-		args = os.Args[1:]
+// Stores a command under its space-joined tokens. Panics when another
+// command reaches the same key with different tokens, as
+// AddTokens([]string{"a b"}) and Add("a b") do, since one would hide the other.
+func (a *App) register(h handler) {
+	key := strings.Join(h.tokens, " ")
+	if old, ok := a.cmds[key]; ok && !slices.Equal(old.tokens, h.tokens) {
+		panic(fmt.Sprintf("command %q conflicts with a command registered with different tokens %q", key, old.tokens))
 	}
+	a.cmds[key] = h
+}
 
-	if len(args) == 0 {
-		// If root handler is registered, show its help as the default; otherwise show global help
-		if a.root != nil {
-			a.printCommandHelp("", *a.root)
-			return nil
+// Panics if a handler parameter is declared in a way that can never parse.
+func (a *App) validateParams(name string, targs []reflect.Type, variadic bool) {
+	owners := make(map[string]int) // option name -> struct parameter declaring it
+	for i, t := range targs {
+		st, ok := structParam(t)
+		if !ok {
+			pt := t
+			if variadic && i == len(targs)-1 {
+				pt = t.Elem()
+			}
+			if !isParsableType(pt) {
+				panic(fmt.Sprintf("unsupported type %s of parameter %d for command %q", t, i, name))
+			}
+			continue
 		}
-		a.printHelp()
-		return nil
-	}
-
-	// try help
-	first := args[0]
-	if first == "-h" || first == "--help" || first == "help" {
-		// if a root handler exists, show root-specific usage; otherwise show general help
-		if a.root != nil {
-			a.printCommandHelp("", *a.root)
-			return nil
+		// missing trailing positionals fall back to their defaults, so an
+		// optional positional cannot be followed by a required one
+		optional := -1
+		for _, p := range structPositionals(st) {
+			if p.optional && optional < 0 {
+				optional = p.pos
+			} else if !p.optional && optional >= 0 {
+				panic(fmt.Sprintf("required positional %s at position %d of parameter %d for command %q follows optional position %d", p.metavar, p.pos, i, name, optional))
+			}
 		}
-		a.printHelp()
-		return nil
-	}
-
-	// match the longest registered command whose tokens are a prefix of args
-	var bestName string
-	var bestHandler handler
-	var bestLen int
-	for name, h := range a.cmds {
-		// split registered name into tokens
-		tokens := strings.Fields(name)
-		if len(tokens) == 0 {
-			continue
+		for _, f := range structFields(st) {
+			if !isFieldType(f.Type) {
+				panic(fmt.Sprintf("unsupported type %s of field %s of parameter %d for command %q", f.Type, f.Name, i, name))
+			}
+			if a.opts.RequireExplicitTags && !hasExplicitTag(f) {
+				panic(fmt.Sprintf("field %s of parameter %d for command %q has no arg, long, short, flag or rest tag", f.Name, i, name))
+			}
+			if err := checkRangeTags(f); err != nil {
+				panic(fmt.Sprintf("field %s of parameter %d for command %q: %v", f.Name, i, name, err))
+			}
+			if err := a.checkDefault(f); err != nil {
+				panic(fmt.Sprintf("field %s of parameter %d for command %q: %v", f.Name, i, name, err))
+			}
+			if f.Tag.Get("arg") == "rest" && (f.Type.Kind() != reflect.Slice || parsesItself(f.Type)) {
+				panic(fmt.Sprintf("rest field %s of parameter %d for command %q must be a slice", f.Name, i, name))
+			}
+			if _, ok := f.Tag.Lookup("rest"); ok && f.Type != reflect.TypeOf([]string(nil)) {
+				panic(fmt.Sprintf("rest field %s of parameter %d for command %q must be []string", f.Name, i, name))
+			}
+			if _, ok := f.Tag.Lookup("toggle"); ok && f.Type.Kind() != reflect.Bool {
+				panic(fmt.Sprintf("toggle field %s of parameter %d for command %q must be bool", f.Name, i, name))
+			}
+			if v, ok := flagValue(f); ok {
+				if v == "" && !isBoolField(f.Type) {
+					panic(fmt.Sprintf("flag field %s of parameter %d for command %q must be bool or have a flag value", f.Name, i, name))
+				}
+				if err := parseAndSetField(reflect.New(f.Type).Elem(), v); err != nil {
+					panic(fmt.Sprintf("invalid flag value %q on field %s of parameter %d for command %q: %v", v, f.Name, i, name, err))
+				}
+			}
+			if isCountField(f) {
+				switch f.Type.Kind() {
+				case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				default:
+					panic(fmt.Sprintf("count field %s of parameter %d for command %q must be an integer", f.Name, i, name))
+				}
+			}
+			v, ok := f.Tag.Lookup("short")
+			if !ok {
+				if !hasLong(f) && isOptionField(f) {
+					panic(fmt.Sprintf("field %s of parameter %d for command %q has long:\"-\" but no short option", f.Name, i, name))
+				}
+				continue
+			}
+			short := strings.TrimPrefix(normalizeShort(v), "-")
+			if short == "" || strings.HasPrefix(short, "-") {
+				panic(fmt.Sprintf("invalid short option %q on field %s of parameter %d for command %q", v, f.Name, i, name))
+			}
+			if utf8.RuneCountInString(short) > 1 && !a.opts.MultiCharShort {
+				panic(fmt.Sprintf("short option %q on field %s of parameter %d for command %q must be a single character (set MultiCharShort to allow longer names)", v, f.Name, i, name))
+			}
 		}
-		if len(tokens) > len(args) {
-			continue
+		if err := a.checkOptionNames(st); err != nil {
+			panic(fmt.Sprintf("parameter %d for command %q: %v", i, name, err))
 		}
-		match := true
-		for i, tok := range tokens {
-			if args[i] != tok {
-				match = false
-				break
+		_, longMap, _ := a.buildFieldMaps(st)
+		for _, f := range structFields(st) {
+			if other, ok := f.Tag.Lookup("only-with"); ok {
+				if _, ok := longMap[other]; !ok {
+					panic(fmt.Sprintf("only-with on field %s of parameter %d for command %q refers to unknown option %s", f.Name, i, name, other))
+				}
 			}
 		}
-		if match && len(tokens) > bestLen {
-			bestLen = len(tokens)
-			bestName = name
-			bestHandler = h
+		for _, f := range structFields(st) {
+			for _, opt := range a.allOptionNames(f) {
+				if o, ok := owners[opt]; ok && o != i {
+					panic(fmt.Sprintf("option %s is declared by both parameter %d and parameter %d for command %q", opt, o, i, name))
+				}
+				owners[opt] = i
+			}
 		}
 	}
+}
 
-	if bestLen == 0 {
-		// If a root handler (registered with name=="") exists, use it
-		if a.root != nil {
-			bestHandler = *a.root
-			bestName = "(root)"
-			// bestLen stays 0 so rawArgs := args[bestLen:] will be full args
-		} else {
-			return a.handleError(fmt.Errorf("unknown command: %s", first))
+// Reports whether a field declares how it is exposed on the command line
+func hasExplicitTag(f reflect.StructField) bool {
+	for _, key := range []string{"arg", "long", "short", "flag", "rest"} {
+		if _, ok := f.Tag.Lookup(key); ok {
+			return true
 		}
 	}
+	return false
+}
 
-	h := bestHandler
-	rawArgs := args[bestLen:]
-	// per-command help: if next token is -h/--help show help for this command
-	if len(rawArgs) > 0 {
-		if rawArgs[0] == "-h" || rawArgs[0] == "--help" {
-			a.printCommandHelp(bestName, h)
-			return nil
-		}
-	}
+// Register a hook called with every parsed struct parameter before the
+// handler runs.
+//
+// The value is addressable, so the hook may modify it. Returning an error
+// aborts the command. Hooks run in registration order.
+func (a *App) OnParsed(fn func(command string, v reflect.Value) error) {
+	a.onParsed = append(a.onParsed, fn)
+}
+
+// Parses the arguments of a handler. Returns the values to call it with,
+// excluding a leading context.Context, and the parsed struct parameters.
+func (a *App) parseArgs(bestName string, h handler, rawArgs []string) ([]reflect.Value, []reflect.Value, error) {
 	// Build parsed arguments. For primitive types we take positional args.
 	parsed := make([]reflect.Value, len(h.targs))
 
@@ -198,10 +693,15 @@ func (a *App) Run(args ...string) error {
 		}
 	}
 
+	// parsed struct values, handed to the OnParsed hooks before the call
+	var structs []reflect.Value
+
 	if usesStruct {
-		// For struct handlers we expect at most one struct parameter (common case).
-		// We'll parse primitives positionally until we reach the struct param, then
-		// parse the struct using flags/position tags from the remaining args.
+		// Parameters parse in order: primitives positionally, and structs
+		// using flags/position tags from the remaining args. A struct
+		// followed by other struct parameters passes their options and the
+		// positionals it has no field for on to them, so that options of
+		// every struct may be mixed freely.
 		ri := 0 // index into rawArgs
 		for i, t := range h.targs {
 			// handle struct or pointer-to-struct
@@ -216,144 +716,759 @@ func (a *App) Run(args ...string) error {
 					wantPtr = true
 				}
 				// parse struct from rawArgs[ri:]
-				sv, nused, err := parseStructArgs(rawArgs[ri:], structType)
+				sv, nused, passed, err := a.parseStructArgs(rawArgs[ri:], structType, a.laterOptions(h.targs[i+1:]))
 				if err != nil {
-					return a.handleError(fmt.Errorf("failed to parse struct arg %d for %s: %w", i+1, bestName, err))
+					var pe *ParseError
+					if errors.As(err, &pe) {
+						pe.Command = bestName
+					}
+					return nil, nil, inCommand(bestName, "", fmt.Errorf("failed to parse struct arg %d for %s: %w", i+1, bestName, err))
 				}
 				if wantPtr {
 					parsed[i] = sv.Addr()
 				} else {
 					parsed[i] = sv
 				}
-				ri += nused
+				structs = append(structs, sv)
+				rawArgs = append(passed, rawArgs[ri+nused:]...)
+				ri = 0
+			} else if h.isVariadicArg(i) {
+				v, bad, err := parseVariadic(rawArgs[ri:], t)
+				if err != nil {
+					return nil, nil, inCommand(bestName, "", atPosition(kindInvalidValue, i, newParseError(bestName, i, "", bad, t.Elem(), err, "failed to parse arg %d for %s: %v", i+1, bestName, err)))
+				}
+				parsed[i] = v
+				ri = len(rawArgs)
 			} else {
 				if ri >= len(rawArgs) {
-					return a.handleError(fmt.Errorf("not enough arguments for %s: want %d, got %d", bestName, len(h.targs), len(rawArgs)))
+					if h.isOptionalArg(i) {
+						parsed[i] = reflect.Zero(t)
+						continue
+					}
+					return nil, nil, inCommand(bestName, kindWrongArgCount, fmt.Errorf("not enough arguments for %s: want %d, got %d", bestName, len(h.targs), len(rawArgs)))
 				}
 				v, err := parseValue(rawArgs[ri], t)
 				if err != nil {
-					return a.handleError(fmt.Errorf("failed to parse arg %d for %s: %w", i+1, bestName, err))
+					return nil, nil, inCommand(bestName, "", atPosition(kindInvalidValue, i, newParseError(bestName, i, "", rawArgs[ri], t, err, "failed to parse arg %d for %s: %v", i+1, bestName, err)))
 				}
 				parsed[i] = v
 				ri++
 			}
 		}
-		// leftover args are ignored
+		// leftover args are ignored unless StrictArgs is set; a `rest` field
+		// captures them instead
+		if a.opts.StrictArgs && ri < len(rawArgs) {
+			return nil, nil, inCommand(bestName, kindWrongArgCount, fmt.Errorf("unexpected arguments for %s: %s", bestName, strings.Join(rawArgs[ri:], " ")))
+		}
 	} else {
-		// Check for unknown options
-		for _, arg := range rawArgs {
-			if strings.HasPrefix(arg, "--") {
-				return a.handleError(fmt.Errorf("unknown option: %s", arg))
+		// Check for unknown options, which IgnoreUnknownOptions drops; args
+		// after a -- terminator are taken verbatim
+		for j := 0; j < len(rawArgs); j++ {
+			arg := rawArgs[j]
+			if arg == "--" {
+				rawArgs = append(rawArgs[:j:j], rawArgs[j+1:]...)
+				break
+			}
+			if !strings.HasPrefix(arg, "--") {
+				continue
+			}
+			if !a.opts.IgnoreUnknownOptions {
+				return nil, nil, inCommand(bestName, "", atOption(kindUnknownOption, arg, &UnknownOptionError{Option: arg}))
 			}
+			rawArgs = append(rawArgs[:j:j], rawArgs[j+1:]...)
+			j--
 		}
-		if len(rawArgs) != len(h.targs) {
-			return a.handleError(fmt.Errorf("wrong number of arguments for %s: want %d, got %d", bestName, len(h.targs), len(rawArgs)))
+		if h.variadic {
+			if fixed := len(h.targs) - 1; len(rawArgs) < fixed {
+				return nil, nil, inCommand(bestName, kindWrongArgCount, fmt.Errorf("wrong number of arguments for %s: want at least %d, got %d", bestName, fixed, len(rawArgs)))
+			}
+		} else if required := h.requiredArgs(); len(rawArgs) < required || len(rawArgs) > len(h.targs) {
+			want := strconv.Itoa(len(h.targs))
+			if required < len(h.targs) {
+				want = fmt.Sprintf("%d to %d", required, len(h.targs))
+			}
+			return nil, nil, inCommand(bestName, kindWrongArgCount, fmt.Errorf("wrong number of arguments for %s: want %s, got %d", bestName, want, len(rawArgs)))
 		}
 
 		for i, t := range h.targs {
+			if h.isVariadicArg(i) {
+				v, bad, err := parseVariadic(rawArgs[i:], t)
+				if err != nil {
+					return nil, nil, inCommand(bestName, "", atPosition(kindInvalidValue, i, newParseError(bestName, i, "", bad, t.Elem(), err, "failed to parse arg %d for %s: %v", i+1, bestName, err)))
+				}
+				parsed[i] = v
+				break
+			}
+			if i >= len(rawArgs) {
+				// omitted optional pointer
+				parsed[i] = reflect.Zero(t)
+				continue
+			}
 			v, err := parseValue(rawArgs[i], t)
 			if err != nil {
-				return a.handleError(fmt.Errorf("failed to parse arg %d for %s: %w", i+1, bestName, err))
+				return nil, nil, inCommand(bestName, "", atPosition(kindInvalidValue, i, newParseError(bestName, i, "", rawArgs[i], t, err, "failed to parse arg %d for %s: %v", i+1, bestName, err)))
 			}
 			parsed[i] = v
 		}
 	}
 
-	res := h.fn.Call(parsed)
+	return parsed, structs, nil
+}
 
-	if h.expectsError {
-		// last return is error
-		last := res[len(res)-1]
-		if !last.IsNil() {
-			return a.handleError(last.Interface().(error))
-		}
+// Calls the handler function, converting a panic into a *PanicError when
+// RecoverPanics is set
+func (a *App) callHandler(h handler, args []reflect.Value) (res []reflect.Value, err error) {
+	if a.opts.RecoverPanics {
+		defer func() {
+			if r := recover(); r != nil {
+				err = &PanicError{Value: r, Stack: debug.Stack()}
+			}
+		}()
 	}
+	if h.variadic {
+		return h.fn.CallSlice(args), nil
+	}
+	return h.fn.Call(args), nil
+}
 
-	return nil
+// Register a hook called before every matched command.
+//
+// Hooks receive the command name and its unparsed arguments, and run in
+// registration order before the arguments are parsed. Returning an error
+// aborts the command; the error is reported like a handler error.
+//
+// For each command the order is: Use hooks, argument parsing, OnParsed
+// hooks, the handler, After hooks.
+func (a *App) Use(fn func(command string, args []string) error) {
+	a.before = append(a.before, fn)
 }
 
-func (a *App) handleError(err error) error {
-	if err == nil {
-		return nil
-	}
-	if a != nil && a.opts != nil && a.opts.ExitOnError {
-		if a.opts.LogError != nil {
-			fmt.Fprintln(a.opts.LogError, err.Error())
-		} else {
-			fmt.Fprintln(os.Stderr, err.Error())
-		}
-		os.Exit(1)
-	}
-	return err
+// Register a hook called after every matched command with its resulting
+// error, which is nil on success.
+//
+// After hooks run in registration order whether the command succeeded,
+// failed to parse or was aborted by a Use hook, and before the error is
+// reported. They do not run for help output or unknown commands.
+func (a *App) After(fn func(command string, err error)) {
+	a.after = append(a.after, fn)
 }
 
-// Prints the common help and version options
-func (a *App) printCommonOptions() {
-	fmt.Fprintln(a.opts.Log, "Options:")
-	fmt.Fprintln(a.opts.Log, "  -h|--help               Show this help")
+// Parses arguments and executes the matching command.
+//
+// Help explicitly requested with -h, --help or help (globally or after a
+// command) is written to Log and Run returns nil; it never exits, whatever
+// ExitOnError is set to. Errors are returned, or written to LogError
+// followed by an exit when ExitOnError is true. They only print help when
+// ShowUsageOnError is set: a command whose arguments fail to parse then
+// writes its help to LogError ahead of the error.
+func (a *App) Run(args ...string) error {
+	return a.RunContext(context.Background(), args...)
 }
 
-// Returns a human-readable label for a type
-func getTypeLabel(t reflect.Type) string {
-	if t.Kind() == reflect.Ptr {
-		t = t.Elem()
-	}
-	switch t.String() {
-	case "string":
-		return "<string>"
-	case "int":
+// Like Run, but passes ctx to handlers whose first parameter is a
+// context.Context. The context parameter is never parsed from the arguments.
+func (a *App) RunContext(ctx context.Context, args ...string) error {
+	return a.run(ctx, args, &runTrace{})
+}
+
+// Like Run, but also reports the dispatched command and how long its handler
+// took. The command is "(root)" for the root handler and "(unknown)" when no
+// command was dispatched (e.g. global help or an unknown command).
+func (a *App) RunTraced(args ...string) (command string, duration time.Duration, err error) {
+	tr := runTrace{command: "(unknown)"}
+	err = a.run(context.Background(), args, &tr)
+	return tr.command, tr.duration, err
+}
+
+// Records what a run dispatched, for RunTraced
+type runTrace struct {
+	command  string
+	duration time.Duration
+}
+
+func (a *App) run(ctx context.Context, args []string, tr *runTrace) error {
+	if args == nil {
+		args = os.Args[1:]
+	}
+	if len(args) > 0 && args[0] == completeCommand {
+		a.complete(args[1:])
+		return nil
+	}
+	args, globals, err := a.parseGlobals(args)
+	if err != nil && !a.requestsHelp(args) {
+		return a.handleError(err)
+	}
+	if globals.IsValid() {
+		a.globals.Elem().Set(globals)
+	}
+
+	if len(args) == 0 {
+		switch a.noArgsBehavior() {
+		case NoArgsError:
+			return a.handleError(inCommand("", kindWrongArgCount, errors.New("no command given")))
+		case NoArgsRunRoot:
+			if a.root != nil {
+				return a.invoke(ctx, "(root)", *a.root, args, tr)
+			}
+		}
+		// If root handler is registered, show its help as the default; otherwise show global help
+		if a.root != nil {
+			a.printCommandHelp(a.opts.Log, "", *a.root)
+			return nil
+		}
+		a.printHelp()
+		return nil
+	}
+
+	// try help
+	first := args[0]
+	if a.isHelpCommand(args) && len(args) > 1 {
+		return a.printHelpTopic(args[1:])
+	}
+	// a help flag before a command name, as in "-h build", shows that command's help
+	if a.isHelpFlag(first) && len(args) > 1 && a.namesCommand(args[1:]) {
+		return a.printHelpTopic(args[1:])
+	}
+	if a.isHelpFlag(first) || a.isHelpCommand(args) {
+		// if a root handler exists, show root-specific usage; otherwise show general help
+		if a.root != nil {
+			a.printCommandHelp(a.opts.Log, "", *a.root)
+			return nil
+		}
+		a.printHelp()
+		return nil
+	}
+
+	if a.isVersionRequest(first) {
+		a.printVersion()
+		return nil
+	}
+
+	bestName, bestHandler, bestLen := a.match(args)
+
+	// an incomplete group path such as "remote" lists the group's subcommands
+	if n := a.groupLen(args); n > bestLen && (n == len(args) || a.isHelpFlag(args[n])) {
+		a.printGroupHelp(args[:n])
+		return nil
+	}
+
+	if bestLen == 0 {
+		// If a root handler (registered with name=="") exists, use it
+		if a.root != nil {
+			bestHandler = *a.root
+			bestName = "(root)"
+			// bestLen stays 0 so rawArgs := args[bestLen:] will be full args
+		} else {
+			return a.handleError(a.unknownCommand(args))
+		}
+	}
+
+	h := bestHandler
+	rawArgs := args[bestLen:]
+	tr.command = bestName
+	// per-command help: if next token is -h/--help show help for this command
+	if len(rawArgs) > 0 {
+		if a.isHelpFlag(rawArgs[0]) {
+			a.printCommandHelp(a.opts.Log, bestName, h)
+			return nil
+		}
+	}
+	return a.invoke(ctx, bestName, h, rawArgs, tr)
+}
+
+// Returns what Run does without arguments, per NoArgsBehavior and
+// RunRootOnEmpty
+func (a *App) noArgsBehavior() NoArgsBehavior {
+	if a.opts.RunRootOnEmpty {
+		return NoArgsRunRoot
+	}
+	return a.opts.NoArgsBehavior
+}
+
+// Parses args like Run but does not call the handler.
+//
+// Returns the matched command ("(root)" for the root command) and the
+// values its handler would be called with, excluding a leading
+// context.Context. Errors are those Run would report; they are returned
+// without being written or exiting, whatever ExitOnError is set to. Args
+// for which Run would print help or the version, including no args under
+// NoArgsShowHelp, return ErrHelp. Use and OnParsed hooks are not run, and
+// global options are checked without being stored in the struct registered
+// with GlobalFlags.
+func (a *App) Parse(args ...string) (string, []any, error) {
+	args, _, err := a.parseGlobals(args)
+	if a.requestsHelp(args) {
+		return "", nil, ErrHelp
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	if len(args) == 0 && a.noArgsBehavior() == NoArgsError {
+		return "", nil, inCommand("", kindWrongArgCount, errors.New("no command given"))
+	}
+	name, h, n := a.match(args)
+	if n == 0 {
+		if a.root == nil {
+			return "", nil, a.unknownCommand(args)
+		}
+		name, h = "(root)", *a.root
+	}
+
+	parsed, _, err := a.parseArgs(name, h, args[n:])
+	if err != nil {
+		return name, nil, err
+	}
+	values := make([]any, len(parsed))
+	for i, v := range parsed {
+		values[i] = v.Interface()
+	}
+	return name, values, nil
+}
+
+// Prints the help of the command or group named by args, as requested with
+// "help <command>"
+func (a *App) printHelpTopic(args []string) error {
+	name, h, n := a.match(args)
+	if g := a.groupLen(args); g > n && g == len(args) {
+		a.printGroupHelp(args)
+		return nil
+	}
+	if n == 0 || n < len(args) {
+		return a.handleError(a.unknownCommand(args))
+	}
+	a.printCommandHelp(a.opts.Log, name, h)
+	return nil
+}
+
+// Reports whether args start with a registered command or group name
+func (a *App) namesCommand(args []string) bool {
+	_, _, n := a.match(args)
+	return n > 0 || a.groupLen(args) > 0
+}
+
+// Returns the error for args that match no command, suggesting the closest
+// command when there is one. A leading option that no global option
+// declares is reported as an unknown option instead.
+func (a *App) unknownCommand(args []string) error {
+	if strings.HasPrefix(args[0], "-") && len(args[0]) > 1 && !isNegativeNumber(args[0]) {
+		return inCommand("", "", atOption(kindUnknownOption, args[0], &UnknownOptionError{Option: args[0]}))
+	}
+	err := &UnknownCommandError{Name: args[0]}
+	err.Suggestion, _ = a.suggestCommand(args)
+	return inCommand(args[0], kindUnknownCommand, err)
+}
+
+// Finds the longest registered command whose tokens are a prefix of args.
+// Returns its canonical name, its handler and the number of args naming it,
+// which is 0 when no command matches.
+func (a *App) match(args []string) (string, handler, int) {
+	var bestName string
+	var bestHandler handler
+	var bestLen int
+	for name, h := range a.cmds {
+		tokens := h.tokens
+		if len(tokens) == 0 {
+			continue
+		}
+		if a.hasTokenPrefix(args, tokens) && len(tokens) > bestLen {
+			bestLen = len(tokens)
+			bestName = name
+			if h.alias != "" {
+				bestName = h.alias
+			}
+			bestHandler = h
+		}
+	}
+
+	return bestName, bestHandler, bestLen
+}
+
+// Parses the arguments of a matched command and calls its handler.
+func (a *App) invoke(ctx context.Context, bestName string, h handler, rawArgs []string, tr *runTrace) error {
+	tr.command = bestName
+	err := a.call(ctx, bestName, h, rawArgs, tr)
+	for _, fn := range a.after {
+		fn(bestName, err)
+	}
+	return a.handleError(err)
+}
+
+// Runs the before hooks, parses the arguments and calls the handler.
+// Errors are returned as is; invoke reports them.
+func (a *App) call(ctx context.Context, bestName string, h handler, rawArgs []string, tr *runTrace) error {
+	for _, fn := range a.before {
+		if err := fn(bestName, rawArgs); err != nil {
+			return err
+		}
+	}
+
+	parsed, structs, err := a.parseArgs(bestName, h, rawArgs)
+	if err != nil {
+		if a.opts.ShowUsageOnError {
+			a.printUsageError(bestName, h)
+		}
+		return err
+	}
+
+	for _, sv := range structs {
+		for _, fn := range a.onParsed {
+			if err := fn(bestName, sv); err != nil {
+				return err
+			}
+		}
+	}
+
+	if h.hasContext {
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		parsed = append([]reflect.Value{reflect.ValueOf(ctx)}, parsed...)
+	}
+
+	start := time.Now()
+	res, err := a.callHandler(h, parsed)
+	tr.duration = time.Since(start)
+	if err != nil {
+		return err
+	}
+
+	if h.expectsError {
+		// last return is error
+		last := res[len(res)-1]
+		if !last.IsNil() {
+			return last.Interface().(error)
+		}
+	}
+
+	return nil
+}
+
+// Prints the help of a command whose arguments failed to parse to LogError.
+// The error itself is left to handleError or the caller.
+func (a *App) printUsageError(bestName string, h handler) {
+	if bestName == "(root)" {
+		bestName = ""
+	}
+	a.printCommandHelp(a.opts.LogError, bestName, h)
+	fmt.Fprintln(a.opts.LogError)
+}
+
+// Reports whether tok looks like a negative number (-5, -3.14, -.5) rather
+// than an option
+func isNegativeNumber(tok string) bool {
+	// require a digit or dot after the dash so clusters like -inf stay options
+	if len(tok) < 2 || tok[0] != '-' || !(tok[1] == '.' || (tok[1] >= '0' && tok[1] <= '9')) {
+		return false
+	}
+	_, err := strconv.ParseFloat(tok, 64)
+	return err == nil
+}
+
+// Returns the options that show help, per Options.HelpFlags
+func (a *App) helpFlags() []string {
+	if a.opts.HelpFlags == nil {
+		return []string{"-h", "--help"}
+	}
+	return a.opts.HelpFlags
+}
+
+// Reports whether tok requests help
+func (a *App) isHelpFlag(tok string) bool {
+	return slices.Contains(a.helpFlags(), tok)
+}
+
+// Reports whether args start with the help command word, per
+// Options.HelpCommand, and name no registered command
+func (a *App) isHelpCommand(args []string) bool {
+	word := a.opts.HelpCommand
+	if word == "" {
+		word = "help"
+	}
+	if word == "-" || args[0] != word {
+		return false
+	}
+	_, _, n := a.match(args)
+	return n == 0
+}
+
+func (a *App) handleError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if a != nil && a.opts != nil && a.opts.ExitOnError {
+		w := a.opts.LogError
+		if w == nil {
+			w = os.Stderr
+		}
+		writeError(w, a.opts.ErrorFormat, err, a.colorEnabled(w))
+		exit := a.opts.Exit
+		if exit == nil {
+			exit = os.Exit
+		}
+		exit(exitCode(err))
+	}
+	return err
+}
+
+// Prints the common help and version options
+//
+// The version option is only accepted before any command, so it is listed
+// for the global and root help only.
+func (a *App) printCommonOptions(w io.Writer, global bool) {
+	rows := a.commonOptionRows(global)
+	if len(rows) == 0 {
+		return
+	}
+	a.printHeading(w, "Options:")
+	a.printRows(w, rows)
+}
+
+func (a *App) commonOptionRows(global bool) []helpRow {
+	var rows []helpRow
+	if flags := a.helpFlags(); len(flags) > 0 {
+		rows = append(rows, helpRow{name: strings.Join(flags, "|"), desc: "Show this help"})
+	}
+	if global && a.hasVersion() {
+		rows = append(rows, helpRow{name: "-v|--version", desc: "Show version information"})
+	}
+	return rows
+}
+
+// A line of a two-column help section. The name is colored, the arg (a
+// value label such as " <string>") is not.
+type helpRow struct {
+	name string
+	arg  string
+	desc string
+}
+
+// minimum width of the first help column, which keeps short option lists
+// aligned with the built-in -h|--help line
+const minHelpColumn = 20
+
+// Prints rows with their descriptions aligned in a second column. Long
+// descriptions are wrapped to the help width.
+func (a *App) printRows(w io.Writer, rows []helpRow) {
+	col := minHelpColumn
+	for _, r := range rows {
+		col = max(col, utf8.RuneCountInString(r.name+r.arg))
+	}
+	indent := 2 + col + 4
+	width := a.helpWidth()
+	for _, r := range rows {
+		label := a.paint(w, ansiCyan, r.name) + r.arg
+		if r.desc == "" {
+			fmt.Fprintf(w, "  %s\n", label)
+			continue
+		}
+		lines := wrapText(r.desc, width-indent)
+		pad := strings.Repeat(" ", col-utf8.RuneCountInString(r.name+r.arg))
+		fmt.Fprintf(w, "  %s%s    %s\n", label, pad, lines[0])
+		for _, l := range lines[1:] {
+			fmt.Fprintf(w, "%s%s\n", strings.Repeat(" ", indent), l)
+		}
+	}
+}
+
+// Returns the width help descriptions are wrapped to: HelpWidth, or the
+// COLUMNS environment variable. Zero disables wrapping.
+func (a *App) helpWidth() int {
+	if a.opts.HelpWidth > 0 {
+		return a.opts.HelpWidth
+	}
+	if v, ok := a.opts.LookupEnv("COLUMNS"); ok {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// minimum width worth wrapping to; narrower columns are left unwrapped
+const minWrapWidth = 20
+
+// Splits s into lines of at most width runes, breaking at spaces. Words
+// longer than width are kept whole.
+func wrapText(s string, width int) []string {
+	if width < minWrapWidth || utf8.RuneCountInString(s) <= width {
+		return []string{s}
+	}
+	var lines []string
+	line := ""
+	for _, w := range strings.Fields(s) {
+		if line != "" && utf8.RuneCountInString(line)+1+utf8.RuneCountInString(w) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += w
+	}
+	return append(lines, line)
+}
+
+// Sets the version printed by --version, -v and the version command.
+func (a *App) SetVersion(v string) {
+	a.opts.Version = v
+}
+
+// Reports whether the first argument asks for the version. -v and the
+// version command give way to a root -v option or a registered version
+// command.
+func (a *App) isVersionRequest(first string) bool {
+	if !a.hasVersion() {
+		return false
+	}
+	switch first {
+	case "--version":
+		return true
+	case "-v":
+		if a.root != nil {
+			for _, o := range a.handlerOptions(*a.root) {
+				if o.short == "-v" {
+					return false
+				}
+			}
+		}
+		return true
+	case "version":
+		_, ok := a.cmds["version"]
+		return !ok
+	}
+	return false
+}
+
+// Reports whether --version has something to print
+func (a *App) hasVersion() bool {
+	return a.opts.Version != "" || a.opts.BuildInfoVersion
+}
+
+// Prints the static version, or the build info when no version is set
+func (a *App) printVersion() {
+	if a.opts.Version != "" {
+		fmt.Fprintln(a.opts.Log, a.opts.Version)
+		return
+	}
+
+	info, ok := readBuildInfo()
+	if !ok {
+		fmt.Fprintln(a.opts.Log, "(unknown)")
+		return
+	}
+	version := info.Main.Version
+	if version == "" {
+		version = "(devel)"
+	}
+	fmt.Fprintf(a.opts.Log, "%s %s\n", info.Main.Path, version)
+	fmt.Fprintf(a.opts.Log, "go: %s\n", info.GoVersion)
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			fmt.Fprintf(a.opts.Log, "revision: %s\n", s.Value)
+		case "vcs.time":
+			fmt.Fprintf(a.opts.Log, "time: %s\n", s.Value)
+		case "vcs.modified":
+			fmt.Fprintf(a.opts.Log, "modified: %s\n", s.Value)
+		}
+	}
+}
+
+// Sets the program name shown in usage lines, the help header and
+// completion scripts. (default is the base name of os.Args[0])
+func (a *App) SetName(name string) {
+	a.name = name
+}
+
+// Returns the program name used in usage lines
+func (a *App) programName() string {
+	if a.name != "" {
+		return a.name
+	}
+	if len(os.Args) > 0 {
+		return filepath.Base(os.Args[0])
+	}
+	return "command"
+}
+
+// Returns a human-readable label for a type
+func getTypeLabel(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if parsesItself(t) && t.Name() != "" {
+		return "<" + toKebab(t.Name()) + ">"
+	}
+	if t.Kind() == reflect.Slice {
+		// repeated options accumulate into slices
+		return getTypeLabel(t.Elem()) + "..."
+	}
+	if t.Kind() == reflect.Map {
+		// repeated key=value options accumulate into maps
+		return "<key=value>..."
+	}
+	switch t.String() {
+	case "string":
+		return "<string>"
+	case "int":
 		return "<int>"
 	case "int64":
 		return "<int64>"
+	case "int8", "int16", "int32", "uint", "uint8", "uint16", "uint32", "uint64":
+		return "<" + t.String() + ">"
+	case "float32":
+		return "<float32>"
 	case "float64":
 		return "<float64>"
 	case "bool":
 		return "<bool>"
+	case "time.Duration":
+		return "<duration>"
 	default:
 		return "<value>"
 	}
 }
 
 func (a *App) printHelp() {
+	if a.opts.HelpTemplate != nil {
+		a.executeHelpTemplate(a.opts.Log, "", nil)
+		return
+	}
+	if a.opts.Version != "" {
+		fmt.Fprintf(a.opts.Log, "%s %s\n\n", a.programName(), a.opts.Version)
+	}
+
 	// If there is no root command, show a minimal Usage line that only
 	// indicates options are available. If a root command exists, keep the
 	// previous more verbose usage header.
 	if a.root == nil {
-		fmt.Fprintln(a.opts.Log, "Usage:")
+		a.printHeading(a.opts.Log, "Usage:")
 		fmt.Fprintln(a.opts.Log, "  [options...]")
 		fmt.Fprintln(a.opts.Log)
 	} else {
-		fmt.Fprintln(a.opts.Log, "Usage:")
+		a.printHeading(a.opts.Log, "Usage:")
 		fmt.Fprintln(a.opts.Log, "  command <args...> [options...]")
 		fmt.Fprintln(a.opts.Log)
 	}
 
-	fmt.Fprintln(a.opts.Log, "Commands:")
-
-	// compute max command name width for alignment
-	max := 0
-	for name := range a.cmds {
-		if len(name) > max {
-			max = len(name)
-		}
-	}
-	for name, h := range a.cmds {
-		if h.help != "" {
-			fmt.Fprintf(a.opts.Log, "  %-*s  %s\n", max, name, h.help)
-		} else {
-			fmt.Fprintf(a.opts.Log, "  %s\n", name)
-		}
-	}
+	a.printHeading(a.opts.Log, "Commands:")
+	a.printCommandList(nil)
 	fmt.Fprintln(a.opts.Log)
 
-	a.printCommonOptions()
+	a.printCommonOptions(a.opts.Log, true)
+	a.printGlobalOptions(a.opts.Log)
 }
 
-func (a *App) printCommandHelp(name string, h handler) {
+func (a *App) printCommandHelp(w io.Writer, name string, h handler) {
+	if a.opts.HelpTemplate != nil {
+		a.executeHelpTemplate(w, name, &h)
+		return
+	}
+
 	// If handler has help text, print it under Usage
 	if h.help != "" {
-		fmt.Fprintln(a.opts.Log, h.help)
-		fmt.Fprintln(a.opts.Log)
+		fmt.Fprintln(w, h.help)
+		fmt.Fprintln(w)
 	}
 
 	// If the handler has only primitive (non-struct) parameters, treat each
@@ -373,178 +1488,487 @@ func (a *App) printCommandHelp(name string, h handler) {
 		cmdName := name
 		if cmdName == "" {
 			// fall back to program name
-			if len(os.Args) > 0 {
-				cmdName = os.Args[0]
-			} else {
-				cmdName = "command"
-			}
+			cmdName = a.programName()
 		}
-		// Usage: cmd <args...>
-		fmt.Fprintln(a.opts.Log, "Usage:")
-		fmt.Fprintf(a.opts.Log, "  %s <args...>\n", cmdName)
-		fmt.Fprintln(a.opts.Log)
+		// Usage: cmd <arg0> <arg1> ...
+		a.printHeading(w, "Usage:")
+		fmt.Fprintf(w, "  %s\n", a.synopsis(cmdName, h))
+		fmt.Fprintln(w)
 
 		// Arguments: show arg index, name (argN) and type
-		fmt.Fprintln(a.opts.Log, "Arguments:")
-		for i, t := range h.targs {
-			tname := getTypeLabel(t)
-			fmt.Fprintf(a.opts.Log, "  [%d] arg%d %s\n", i, i, tname)
+		a.printHeading(w, "Arguments:")
+		for i := range h.targs {
+			fmt.Fprintf(w, "  [%d] arg%d %s\n", i, i, h.argLabel(i))
 		}
-		fmt.Fprintln(a.opts.Log)
+		fmt.Fprintln(w)
 
 		// Options: only built-in help/version shown for primitive-only handlers
-		a.printCommonOptions()
+		a.printCommonOptions(w, name == "")
+		a.printGlobalOptions(w)
 		return
 	}
 
 	// Usage header will be printed after we discover positional arguments
 
 	// Collect positional args (arg tags)
-	posMap := map[int]string{}
+	posMap := map[int]positionalSpec{}
 	maxPos := -1
-	for _, t := range h.targs {
-		var st reflect.Type
-		if t.Kind() == reflect.Struct {
-			st = t
-		} else if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
-			st = t.Elem()
-		} else {
-			continue
-		}
-		for i := 0; i < st.NumField(); i++ {
-			f := st.Field(i)
-			if v, ok := f.Tag.Lookup("arg"); ok {
-				n, err := strconv.Atoi(v)
-				if err == nil {
-					// If description tag present, prefer it as the argument name
-					if d, ok := f.Tag.Lookup("help"); ok && d != "" {
-						posMap[n] = d
-					} else {
-						posMap[n] = toWords(f.Name)
-					}
-					if n > maxPos {
-						maxPos = n
-					}
-				}
-			}
+	for _, p := range handlerPositionals(h) {
+		posMap[p.pos] = p
+		if p.pos > maxPos {
+			maxPos = p.pos
 		}
 	}
 
 	// Usage
 	cmdName := name
 	if cmdName == "" {
-		if len(os.Args) > 0 {
-			cmdName = os.Args[0]
-		} else {
-			cmdName = "command"
-		}
+		cmdName = a.programName()
 	}
-	fmt.Fprintln(a.opts.Log, "Usage:")
-	if maxPos >= 0 {
-		fmt.Fprintf(a.opts.Log, "  %s <args...> [options...]\n", cmdName)
-	} else {
-		fmt.Fprintf(a.opts.Log, "  %s [options...]\n", cmdName)
-	}
-	fmt.Fprintln(a.opts.Log)
-	fmt.Fprintln(a.opts.Log)
+	a.printHeading(w, "Usage:")
+	fmt.Fprintf(w, "  %s\n", a.synopsis(cmdName, h))
+	fmt.Fprintln(w)
+	fmt.Fprintln(w)
 
 	// Arguments section
 	if maxPos >= 0 {
-		fmt.Fprintln(a.opts.Log, "Arguments:")
+		a.printHeading(w, "Arguments:")
 		for i := 0; i <= maxPos; i++ {
-			name := posMap[i]
-			if name == "" {
+			p, ok := posMap[i]
+			name := p.name
+			if !ok {
 				name = "arg" + strconv.Itoa(i)
 			}
-			fmt.Fprintf(a.opts.Log, "  [%d] %s\n", i, name)
+			if p.hasDef {
+				fmt.Fprintf(w, "  [%d] %s (default: %s)\n", i, name, p.def)
+			} else {
+				fmt.Fprintf(w, "  [%d] %s\n", i, name)
+			}
 		}
-		fmt.Fprintln(a.opts.Log)
+		fmt.Fprintln(w)
 	}
 
 	// If printing root usage (name == ""), include a Commands list of subcommands
 	if name == "" {
-		fmt.Fprintln(a.opts.Log, "Commands:")
+		a.printHeading(w, "Commands:")
+		names := make([]string, 0, len(a.cmds))
 		for cname, ch := range a.cmds {
-			fmt.Fprintf(a.opts.Log, "  %s (args: %d)\n", cname, len(ch.targs))
+			if ch.alias == "" && !ch.hidden {
+				names = append(names, cname)
+			}
 		}
-		fmt.Fprintln(a.opts.Log)
+		sort.Strings(names)
+		for _, cname := range names {
+			fmt.Fprintf(w, "  %s (args: %d)\n", a.paint(w, ansiCyan, cname), len(a.cmds[cname].targs))
+		}
+		fmt.Fprintln(w)
 	}
 
-	// Options
-	fmt.Fprintln(a.opts.Log, "Options:")
-	fmt.Fprintln(a.opts.Log, "  -h|--help               Show this help")
+	// Options: print option fields (non-positional) in declaration order,
+	// aligned with the common options
+	a.printHeading(w, "Options:")
+	rows := a.commonOptionRows(name == "")
+	var deprecated []optionSpec
+	for _, o := range a.handlerOptions(h) {
+		if o.hidden {
+			continue
+		}
+		if o.deprecated != "" {
+			deprecated = append(deprecated, o)
+			continue
+		}
+		rows = append(rows, a.optionRow(o))
+	}
+	a.printRows(w, rows)
+
+	if len(deprecated) > 0 {
+		fmt.Fprintln(w)
+		a.printHeading(w, "Deprecated:")
+		rows = nil
+		for _, o := range deprecated {
+			r := helpRow{name: o.long, desc: o.deprecated}
+			if o.short != "" {
+				r.name = o.short + "|" + r.name
+			}
+			if !o.isFlag {
+				r.arg = " " + o.typeLabel
+			}
+			rows = append(rows, r)
+		}
+		a.printRows(w, rows)
+	}
+	a.printGlobalOptions(w)
+}
 
-	// Print option fields (non-positional)
-	for _, t := range h.targs {
-		var st reflect.Type
-		if t.Kind() == reflect.Struct {
-			st = t
-		} else if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
-			st = t.Elem()
-		} else {
+// Returns the help row of an option: its names, value label and help text
+// followed by notes such as its default
+func (a *App) optionRow(o optionSpec) helpRow {
+	typeLabel := ""
+	if !o.isFlag {
+		typeLabel = " " + o.typeLabel
+	}
+	longName := o.long
+	if o.negated != "" {
+		longName += "|" + o.negated
+	}
+	desc := o.help
+	if o.repeatable {
+		desc = strings.TrimSpace(desc + " (repeatable)")
+	}
+	if o.count {
+		desc = strings.TrimSpace(desc + " (repeatable, counts occurrences)")
+	}
+	if len(o.choices) > 0 {
+		desc = strings.TrimSpace(desc + " (choices: " + strings.Join(o.choices, ", ") + ")")
+	}
+	if o.rng != "" {
+		desc = strings.TrimSpace(desc + " (" + o.rng + ")")
+	}
+	if len(o.exclusive) > 0 {
+		desc = strings.TrimSpace(desc + " (not with " + strings.Join(o.exclusive, ", ") + ")")
+	}
+	if o.env != "" {
+		desc = strings.TrimSpace(desc + " (env: " + o.env + ")")
+	}
+	if o.hasDef {
+		desc = strings.TrimSpace(desc + " (default: " + o.def + ")")
+	}
+	if o.required {
+		desc = strings.TrimSpace(desc + " (required)")
+	}
+
+	if o.short != "" {
+		longName = o.short + "|" + longName
+	}
+	return helpRow{name: longName, arg: typeLabel, desc: desc}
+}
+
+// Builds a one-line usage synopsis from the handler's parameters.
+// Optional arguments and options are bracketed:
+//
+//	cp <src> [<dst>] [--force] [--out <string>]
+func (a *App) synopsis(cmdName string, h handler) string {
+	parts := []string{cmdName}
+	for i, t := range h.targs {
+		st, ok := structParam(t)
+		if !ok {
+			if h.isVariadicArg(i) {
+				parts = append(parts, "[<arg"+strconv.Itoa(i)+">...]")
+			} else if h.isOptionalArg(i) {
+				parts = append(parts, "[<arg"+strconv.Itoa(i)+">]")
+			} else {
+				parts = append(parts, "<arg"+strconv.Itoa(i)+">")
+			}
 			continue
 		}
-		for i := 0; i < st.NumField(); i++ {
-			f := st.Field(i)
-			tag := f.Tag
-			if _, ok := tag.Lookup("arg"); ok {
-				// skip positional fields from options
-				continue
+		for _, p := range structPositionals(st) {
+			if p.optional {
+				parts = append(parts, "[<"+p.metavar+">]")
+			} else {
+				parts = append(parts, "<"+p.metavar+">")
 			}
-			longName := "--" + toKebab(f.Name)
-			if v, ok := tag.Lookup("long"); ok && v != "" {
-				longName = v
+		}
+		fields := structFields(st)
+		if fi, ok := restArgField(fields); ok {
+			parts = append(parts, "[<"+toKebab(fields[fi].Name)+"...>]")
+		}
+		if fi, ok := restField(fields); ok {
+			parts = append(parts, "[<"+toKebab(fields[fi].Name)+">...]")
+		}
+		for _, o := range a.structOptions(st) {
+			if o.hidden || o.deprecated != "" {
+				continue
 			}
-			shortName := ""
-			if v, ok := tag.Lookup("short"); ok && v != "" {
-				shortName = v
+			name := o.long
+			if o.toggle {
+				name += "|" + o.negated
 			}
-			desc := ""
-			if d, ok := tag.Lookup("help"); ok {
-				desc = d
+			if !o.isFlag {
+				name += " " + o.typeLabel
 			}
-			// Determine if this option should be shown as a flag (no value)
-			// Treat bool and *bool as flags; ignore explicit `flag` tag.
-			isFlag := false
-			if f.Type.Kind() == reflect.Bool || (f.Type.Kind() == reflect.Ptr && f.Type.Elem().Kind() == reflect.Bool) {
-				isFlag = true
+			if o.required {
+				parts = append(parts, name)
+			} else {
+				parts = append(parts, "["+name+"]")
 			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
 
-			typeLabel := ""
-			if !isFlag {
-				typeLabel = " " + getTypeLabel(f.Type)
+// Describes a positional field of a struct parameter.
+type positionalSpec struct {
+	pos       int
+	name      string // help text, or the field name in words
+	metavar   string // field name in kebab-case, used in the synopsis
+	typeLabel string
+	def       string
+	hasDef    bool
+	optional  bool // has a default or is tagged required:"false"
+}
+
+// Collects the positional fields of all struct parameters of a handler.
+// Positions of later struct parameters follow those of earlier ones.
+func handlerPositionals(h handler) []positionalSpec {
+	var ps []positionalSpec
+	for _, t := range h.targs {
+		st, ok := structParam(t)
+		if !ok {
+			continue
+		}
+		offset := 0
+		if len(ps) > 0 {
+			offset = ps[len(ps)-1].pos + 1
+		}
+		for _, p := range structPositionals(st) {
+			p.pos += offset
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// Collects the positional fields of a struct type ordered by position
+func structPositionals(st reflect.Type) []positionalSpec {
+	var ps []positionalSpec
+	for _, f := range structFields(st) {
+		v, ok := f.Tag.Lookup("arg")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		p := positionalSpec{pos: n, name: toWords(f.Name), metavar: toKebab(f.Name), typeLabel: getTypeLabel(f.Type)}
+		// If description tag present, prefer it as the argument name
+		if d, ok := f.Tag.Lookup("help"); ok && d != "" {
+			p.name = d
+		}
+		p.def, p.hasDef = f.Tag.Lookup("default")
+		if _, ok := f.Tag.Lookup("required"); p.hasDef || (ok && !isRequired(f)) {
+			p.optional = true
+		}
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i].pos < ps[j].pos })
+	return ps
+}
+
+// Describes a named (non-positional) option of a struct parameter.
+// Shared by help output and the completion generators.
+type optionSpec struct {
+	long       string
+	negated    string // --no- form of bool options
+	short      string
+	help       string
+	isFlag     bool
+	repeatable bool // slice option that accumulates repeated values
+	typeLabel  string
+	def        string
+	hasDef     bool
+	required   bool
+	env        string
+	toggle     bool // enabled by default, shown with its --no- form in the synopsis
+	count      bool // int flag counting its occurrences
+	choices    []string
+	rng        string   // allowed range from `min` and `max` tags
+	hidden     bool     // left out of help and completion
+	deprecated string   // `deprecated` tag; listed in its own help section
+	exclusive  []string // other options of its exclusive group
+}
+
+// Returns the struct type of a struct or pointer-to-struct parameter
+func structParam(t reflect.Type) (reflect.Type, bool) {
+	if t.Kind() == reflect.Struct {
+		return t, true
+	}
+	if t.Kind() == reflect.Ptr && t.Elem().Kind() == reflect.Struct {
+		return t.Elem(), true
+	}
+	return nil, false
+}
+
+// Collects the named options of all struct parameters of a handler
+func (a *App) handlerOptions(h handler) []optionSpec {
+	var opts []optionSpec
+	for _, t := range h.targs {
+		st, ok := structParam(t)
+		if !ok {
+			continue
+		}
+		opts = append(opts, a.structOptions(st)...)
+	}
+	return opts
+}
+
+// Collects the named options of a struct type in field order
+func (a *App) structOptions(st reflect.Type) []optionSpec {
+	var opts []optionSpec
+	fields := structFields(st)
+	groups := exclusiveGroups(fields)
+	for fi, f := range fields {
+		tag := f.Tag
+		if !isOptionField(f) {
+			// skip positional and rest fields from options
+			continue
+		}
+		o := optionSpec{long: a.optionName(f)}
+		if v, ok := tag.Lookup("short"); ok && v != "" && hasLong(f) {
+			o.short = normalizeShort(v)
+		}
+		if d, ok := tag.Lookup("help"); ok {
+			o.help = d
+		}
+		if c, ok := tag.Lookup("choices"); ok {
+			o.choices = splitChoices(c)
+		}
+		o.hidden = isHidden(f)
+		for _, gi := range groups[tag.Get("group")] {
+			if gi != fi {
+				o.exclusive = append(o.exclusive, a.optionName(fields[gi]))
+			}
+		}
+		if d, ok := tag.Lookup("deprecated"); ok {
+			o.deprecated = d
+			if d == "" {
+				o.deprecated = "deprecated"
 			}
+		}
+		lo, hasMin := tag.Lookup("min")
+		hi, hasMax := tag.Lookup("max")
+		if hasMin || hasMax {
+			o.rng = rangeText(lo, hasMin, hi, hasMax)
+		}
+		o.def, o.hasDef = tag.Lookup("default")
+		o.env = tag.Get("env")
+		o.required = isRequired(f) && !o.hasDef
+		_, o.toggle = tag.Lookup("toggle")
+		// bool, count and `flag` fields take no value
+		o.isFlag = isFlagField(f)
+		o.count = isCountField(f)
+		if isBoolField(f.Type) && hasLong(f) {
+			o.negated = negatedName(o.long)
+		}
+		o.typeLabel = getTypeLabel(f.Type)
+		o.repeatable = (f.Type.Kind() == reflect.Slice || f.Type.Kind() == reflect.Map) && !parsesItself(f.Type)
+		opts = append(opts, o)
+	}
+	return opts
+}
+
+// Reports whether parameter i is the variadic parameter of the handler
+func (h handler) isVariadicArg(i int) bool {
+	return h.variadic && i == len(h.targs)-1
+}
+
+// Reports whether positional parameter i may be omitted: it and all the
+// parameters after it are pointers, which are nil when omitted
+func (h handler) isOptionalArg(i int) bool {
+	for _, t := range h.targs[i:] {
+		if _, ok := structParam(t); ok || t.Kind() != reflect.Ptr {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the number of positional parameters that may not be omitted
+func (h handler) requiredArgs() int {
+	for i := range h.targs {
+		if h.isOptionalArg(i) {
+			return i
+		}
+	}
+	return len(h.targs)
+}
+
+// Returns the type label of parameter i; the variadic parameter of type
+// []string is labeled <string...>
+func (h handler) argLabel(i int) string {
+	if h.isVariadicArg(i) {
+		return strings.TrimSuffix(getTypeLabel(h.targs[i].Elem()), ">") + "...>"
+	}
+	return getTypeLabel(h.targs[i])
+}
+
+// Parses each of args to the element type of the slice type t
+func parseVariadic(args []string, t reflect.Type) (reflect.Value, string, error) {
+	s := reflect.MakeSlice(t, 0, len(args))
+	for _, arg := range args {
+		v, err := parseValue(arg, t.Elem())
+		if err != nil {
+			return reflect.Value{}, arg, err
+		}
+		s = reflect.Append(s, v)
+	}
+	return s, "", nil
+}
+
+// parseValue parses a string value to the given target type
+func parseValue(s string, targetType reflect.Type) (reflect.Value, error) {
+	// types implementing flag.Value or encoding.TextUnmarshaler parse themselves
+	if parsesItself(targetType) {
+		return parseSelf(s, targetType)
+	}
+
+	// pointers are parsed to a new value of their element type
+	if targetType.Kind() == reflect.Ptr {
+		v, err := parseValue(s, targetType.Elem())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(targetType.Elem())
+		p.Elem().Set(v)
+		return p, nil
+	}
 
-			if shortName != "" {
-				fmt.Fprintf(a.opts.Log, "  %s|%s%s    %s\n", shortName, longName, typeLabel, desc)
-			} else {
-				fmt.Fprintf(a.opts.Log, "  %s%s    %s\n", longName, typeLabel, desc)
-			}
+	// time.Duration is an int64 kind, so match the type before the kind
+	if targetType == durationType {
+		v, err := time.ParseDuration(s)
+		if err != nil {
+			return reflect.Value{}, err
 		}
+		return reflect.ValueOf(v), nil
 	}
-}
 
-// parseValue parses a string value to the given target type
-func parseValue(s string, targetType reflect.Type) (reflect.Value, error) {
 	switch targetType.Kind() {
 	case reflect.String:
 		return reflect.ValueOf(s), nil
 	case reflect.Int:
 		v, err := strconv.Atoi(s)
 		if err != nil {
-			return reflect.Value{}, err
+			return reflect.Value{}, rangeError(s, targetType, err)
 		}
 		return reflect.ValueOf(v), nil
 	case reflect.Int64:
 		v, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
-			return reflect.Value{}, err
+			return reflect.Value{}, rangeError(s, targetType, err)
 		}
 		return reflect.ValueOf(v), nil
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		v, err := strconv.ParseInt(s, 10, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, rangeError(s, targetType, err)
+		}
+		return reflect.ValueOf(v).Convert(targetType), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(s, 10, targetType.Bits())
+		if err != nil {
+			return reflect.Value{}, rangeError(s, targetType, err)
+		}
+		return reflect.ValueOf(v).Convert(targetType), nil
+	case reflect.Float32:
+		v, err := strconv.ParseFloat(s, 32)
+		if err != nil {
+			return reflect.Value{}, rangeError(s, targetType, err)
+		}
+		return reflect.ValueOf(v).Convert(targetType), nil
 	case reflect.Float64:
 		v, err := strconv.ParseFloat(s, 64)
 		if err != nil {
-			return reflect.Value{}, err
+			return reflect.Value{}, rangeError(s, targetType, err)
 		}
 		return reflect.ValueOf(v), nil
 	case reflect.Bool:
@@ -558,6 +1982,51 @@ func parseValue(s string, targetType reflect.Type) (reflect.Value, error) {
 	}
 }
 
+// Reports whether parseValue can parse a value of type t
+func isParsableType(t reflect.Type) bool {
+	if parsesItself(t) || t == durationType {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return isParsableType(t.Elem())
+	case reflect.String, reflect.Bool, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	}
+	return false
+}
+
+// Reports whether parseAndSetField can set a struct field of type t
+func isFieldType(t reflect.Type) bool {
+	switch {
+	case t.Kind() == reflect.Ptr:
+		return isParsableType(t.Elem())
+	case t.Kind() == reflect.Slice && !parsesItself(t):
+		return isParsableType(t.Elem())
+	case t.Kind() == reflect.Map && !parsesItself(t):
+		return isParsableType(t.Key()) && isParsableType(t.Elem())
+	}
+	return isParsableType(t)
+}
+
+// Replaces a strconv range error with one naming the allowed range
+func rangeError(s string, t reflect.Type, err error) error {
+	if !errors.Is(err, strconv.ErrRange) {
+		return err
+	}
+	bits := t.Bits()
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return fmt.Errorf("%s is out of range for %s", s, t)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return fmt.Errorf("%s is out of range for %s (0 to %d)", s, t, uint64(math.MaxUint64)>>(64-bits))
+	default:
+		return fmt.Errorf("%s is out of range for %s (%d to %d)", s, t, int64(-1)<<(bits-1), int64(math.MaxInt64)>>(64-bits))
+	}
+}
+
 // Parses a string value and sets it to a field, handling pointer types
 func parseAndSetField(field reflect.Value, value string) error {
 	fieldType := field.Type()
@@ -575,6 +2044,37 @@ func parseAndSetField(field reflect.Value, value string) error {
 		return nil
 	}
 
+	// Handle slices: every occurrence appends one element
+	if fieldType.Kind() == reflect.Slice && !parsesItself(fieldType) {
+		parsedValue, err := parseValue(value, fieldType.Elem())
+		if err != nil {
+			return err
+		}
+		field.Set(reflect.Append(field, parsedValue))
+		return nil
+	}
+
+	// Handle maps: every occurrence adds one key=value entry
+	if fieldType.Kind() == reflect.Map && !parsesItself(fieldType) {
+		k, v, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("expected key=value, got %q", value)
+		}
+		key, err := parseValue(k, fieldType.Key())
+		if err != nil {
+			return err
+		}
+		elem, err := parseValue(v, fieldType.Elem())
+		if err != nil {
+			return err
+		}
+		if field.IsNil() {
+			field.Set(reflect.MakeMap(fieldType))
+		}
+		field.SetMapIndex(key, elem)
+		return nil
+	}
+
 	// Handle direct types
 	parsedValue, err := parseValue(value, fieldType)
 	if err != nil {
@@ -584,132 +2084,780 @@ func parseAndSetField(field reflect.Value, value string) error {
 	return nil
 }
 
+// Reports whether t implements flag.Value or encoding.TextUnmarshaler
+func isSelfParser(t reflect.Type) bool {
+	return t.Implements(flagValueType) || t.Implements(textUnmarshalerType)
+}
+
+// Reports whether values of t, or pointers to them, parse themselves
+func parsesItself(t reflect.Type) bool {
+	return isSelfParser(t) || (t.Kind() != reflect.Ptr && isSelfParser(reflect.PointerTo(t)))
+}
+
+// Parses s into a new value of t through its Set or UnmarshalText method.
+// flag.Value is preferred when a type implements both.
+func parseSelf(s string, t reflect.Type) (reflect.Value, error) {
+	isPtr := t.Kind() == reflect.Ptr && isSelfParser(t)
+	elem := t
+	if isPtr {
+		elem = t.Elem()
+	}
+
+	v := reflect.New(elem)
+	var err error
+	switch u := v.Interface().(type) {
+	case flag.Value:
+		err = u.Set(s)
+	case encoding.TextUnmarshaler:
+		err = u.UnmarshalText([]byte(s))
+	}
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if isPtr {
+		return v, nil
+	}
+	return v.Elem(), nil
+}
+
+// Sets a struct field from a command-line value, resolving indirect values
+// for fields tagged `indirect` and "-" for fields tagged `stdin`.
+func (a *App) setField(sv reflect.Value, f reflect.StructField, value string) error {
+	// a field tagged `stdin:"true"` reads all of Input for a "-" value
+	if value == "-" && isStdinField(f) {
+		b, err := io.ReadAll(a.opts.Input)
+		if err != nil {
+			return err
+		}
+		value = string(b)
+	}
+	if _, ok := f.Tag.Lookup("indirect"); ok {
+		v, err := a.resolveIndirect(value)
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	if choices, ok := f.Tag.Lookup("choices"); ok {
+		v, err := a.matchChoice(value, splitChoices(choices))
+		if err != nil {
+			return err
+		}
+		value = v
+	}
+	fv := fieldValue(sv, f.Index)
+	if err := parseAndSetField(fv, value); err != nil {
+		return err
+	}
+	return a.checkRange(f, fv)
+}
+
+// Checks the value just set on a field tagged `min` or `max`. For slices
+// every element is checked.
+func (a *App) checkRange(f reflect.StructField, fv reflect.Value) error {
+	lo, hasMin := f.Tag.Lookup("min")
+	hi, hasMax := f.Tag.Lookup("max")
+	if !hasMin && !hasMax {
+		return nil
+	}
+	if fv.Kind() == reflect.Ptr {
+		if fv.IsNil() {
+			return nil
+		}
+		fv = fv.Elem()
+	}
+	if fv.Kind() == reflect.Slice {
+		for j := 0; j < fv.Len(); j++ {
+			if err := a.checkRange(f, fv.Index(j)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var n float64
+	switch fv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(fv.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(fv.Uint())
+	default:
+		n = fv.Float()
+	}
+	minV, _ := strconv.ParseFloat(lo, 64)
+	maxV, _ := strconv.ParseFloat(hi, 64)
+	if (hasMin && n < minV) || (hasMax && n > maxV) {
+		name := a.optionName(f)
+		if !isOptionField(f) {
+			name = toKebab(f.Name)
+		}
+		return fmt.Errorf("%s must be %s", name, rangeText(lo, hasMin, hi, hasMax))
+	}
+	return nil
+}
+
+// Describes the range given by `min` and `max` tags
+//   - 1, 65535 -> between 1 and 65535
+func rangeText(lo string, hasMin bool, hi string, hasMax bool) string {
+	switch {
+	case hasMin && hasMax:
+		return "between " + lo + " and " + hi
+	case hasMin:
+		return "at least " + lo
+	default:
+		return "at most " + hi
+	}
+}
+
+// Reports misuse of `min` and `max` tags: on a non-numeric field, or with
+// a bound that is not a number
+func checkRangeTags(f reflect.StructField) error {
+	t := f.Type
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	for _, tag := range []string{"min", "max"} {
+		v, ok := f.Tag.Lookup(tag)
+		if !ok {
+			continue
+		}
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+		default:
+			return fmt.Errorf("%s tag requires a numeric field", tag)
+		}
+		if t == durationType {
+			return fmt.Errorf("%s tag is not supported on durations", tag)
+		}
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return fmt.Errorf("invalid %s %q", tag, v)
+		}
+	}
+	return nil
+}
+
+// Reports a `default` tag that is not one of the field's choices or lies
+// outside its `min` and `max`
+func (a *App) checkDefault(f reflect.StructField) error {
+	def, ok := f.Tag.Lookup("default")
+	if !ok {
+		return nil
+	}
+	if choices, ok := f.Tag.Lookup("choices"); ok {
+		if _, err := a.matchChoice(def, splitChoices(choices)); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	_, hasMin := f.Tag.Lookup("min")
+	_, hasMax := f.Tag.Lookup("max")
+	if hasMin || hasMax {
+		fv := reflect.New(f.Type).Elem()
+		if err := parseAndSetField(fv, def); err != nil {
+			return fmt.Errorf("invalid default %q: %w", def, err)
+		}
+		if err := a.checkRange(f, fv); err != nil {
+			return fmt.Errorf("default: %w", err)
+		}
+	}
+	return nil
+}
+
+// Checks a value decoded from a config file, rather than parsed from a
+// string, against the field's `choices`. For slices every element is checked.
+func (a *App) checkChoices(f reflect.StructField, fv reflect.Value) error {
+	choices, ok := f.Tag.Lookup("choices")
+	if !ok {
+		return nil
+	}
+	fv = reflect.Indirect(fv)
+	values := []reflect.Value{fv}
+	if fv.Kind() == reflect.Slice {
+		values = values[:0]
+		for j := 0; j < fv.Len(); j++ {
+			values = append(values, fv.Index(j))
+		}
+	}
+	for _, v := range values {
+		if _, err := a.matchChoice(fmt.Sprint(v.Interface()), splitChoices(choices)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the choice value matches, per ChoicesIgnoreCase
+func (a *App) matchChoice(value string, choices []string) (string, error) {
+	for _, c := range choices {
+		if c == value || (a.opts.ChoicesIgnoreCase && strings.EqualFold(c, value)) {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("invalid value %q (choices: %s)", value, strings.Join(choices, ", "))
+}
+
+// Splits a `choices` tag into its comma-separated values
+func splitChoices(tag string) []string {
+	var choices []string
+	for _, c := range strings.Split(tag, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			choices = append(choices, c)
+		}
+	}
+	return choices
+}
+
+// Resolves an indirect value:
+//
+//   - @file:path - contents of the file
+//   - @env:NAME  - value of the environment variable
+//   - @-         - contents of Input (stdin)
+//
+// A single trailing newline is trimmed from file and stdin contents. Values
+// that do not start with @ are returned unchanged.
+func (a *App) resolveIndirect(value string) (string, error) {
+	switch {
+	case !strings.HasPrefix(value, "@"):
+		return value, nil
+	case strings.HasPrefix(value, "@file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(value, "@file:"))
+		if err != nil {
+			return "", err
+		}
+		return trimNewline(string(b)), nil
+	case strings.HasPrefix(value, "@env:"):
+		name := strings.TrimPrefix(value, "@env:")
+		v, ok := a.opts.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case value == "@-":
+		b, err := io.ReadAll(a.opts.Input)
+		if err != nil {
+			return "", err
+		}
+		return trimNewline(string(b)), nil
+	default:
+		return "", fmt.Errorf("unsupported indirect value %q (want @file:path, @env:NAME or @-)", value)
+	}
+}
+
+// Trims a single trailing newline
+func trimNewline(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	return strings.TrimSuffix(s, "\r")
+}
+
 // Sets a boolean field (including pointer types) to true
 func setBoolField(field reflect.Value) {
+	setBoolFieldTo(field, true)
+}
+
+// Sets a bool or *bool field to v
+func setBoolFieldTo(field reflect.Value, v bool) {
 	fieldType := field.Type()
 
 	if fieldType.Kind() == reflect.Bool {
-		field.SetBool(true)
+		field.SetBool(v)
 	} else if fieldType.Kind() == reflect.Ptr && fieldType.Elem().Kind() == reflect.Bool {
 		ptr := reflect.New(fieldType.Elem())
-		ptr.Elem().SetBool(true)
+		ptr.Elem().SetBool(v)
 		field.Set(ptr)
 	}
 }
 
+// Returns the exclusive option groups of a struct: groups named by the
+// `group` tag of which at least one member is tagged `exclusive:"true"`.
+// Members are field indexes in declaration order.
+func exclusiveGroups(fields []reflect.StructField) map[string][]int {
+	groups := make(map[string][]int)
+	exclusive := make(map[string]bool)
+	for i, f := range fields {
+		g, ok := f.Tag.Lookup("group")
+		if !ok || g == "" || !isOptionField(f) {
+			continue
+		}
+		groups[g] = append(groups[g], i)
+		if v, ok := f.Tag.Lookup("exclusive"); ok {
+			b, err := strconv.ParseBool(v)
+			exclusive[g] = exclusive[g] || err != nil || b
+		}
+	}
+	for g := range groups {
+		if !exclusive[g] {
+			delete(groups, g)
+		}
+	}
+	return groups
+}
+
+// Reports an error when more than one option of an exclusive group was
+// given on the command line
+func (a *App) checkExclusive(fields []reflect.StructField, set map[int]bool) error {
+	groups := exclusiveGroups(fields)
+	names := make([]string, 0, len(groups))
+	for g := range groups {
+		names = append(names, g)
+	}
+	sort.Strings(names)
+
+	for _, g := range names {
+		var given []string
+		for _, fi := range groups[g] {
+			if set[fi] {
+				given = append(given, a.optionName(fields[fi]))
+			}
+		}
+		if len(given) > 1 {
+			return atOption(kindValidation, given[1], fmt.Errorf("options %s cannot be used together", strings.Join(given, " and ")))
+		}
+	}
+	return nil
+}
+
+// Warns about every option tagged `deprecated` that was given on the
+// command line
+func (a *App) warnDeprecated(fields []reflect.StructField, set map[int]bool) {
+	for i, f := range fields {
+		msg, ok := f.Tag.Lookup("deprecated")
+		if !ok || !set[i] || !isOptionField(f) {
+			continue
+		}
+		warning := a.optionName(f) + " is deprecated"
+		if msg != "" {
+			warning += ": " + msg
+		}
+		fmt.Fprintln(a.opts.LogWarning, warning)
+	}
+}
+
+// Reports whether a field has the tag key with a value that is empty or
+// not false, e.g. `hidden:""` or `hidden:"true"`
+func hasFlagTag(f reflect.StructField, key string) bool {
+	v, ok := f.Tag.Lookup(key)
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(v)
+	return err != nil || b
+}
+
+// Reports whether a field is tagged `stdin:"true"`
+func isStdinField(f reflect.StructField) bool {
+	return hasFlagTag(f, "stdin")
+}
+
+// Reports whether a field is tagged `hidden:"true"`
+func isHidden(f reflect.StructField) bool {
+	return hasFlagTag(f, "hidden")
+}
+
+// Reports whether a field is tagged `count:"true"`, counting how often its
+// flag is given
+func isCountField(f reflect.StructField) bool {
+	return hasFlagTag(f, "count")
+}
+
+// Reports whether a field is an option that takes no value
+func isFlagField(f reflect.StructField) bool {
+	_, ok := f.Tag.Lookup("flag")
+	return ok || isBoolField(f.Type) || isCountField(f)
+}
+
+// Returns the value a field tagged `flag` takes when its option is given:
+// the tag value, which defaults to true for bool fields
+//   - `flag:"debug"` on a string field -> debug
+func flagValue(f reflect.StructField) (string, bool) {
+	v, ok := f.Tag.Lookup("flag")
+	if ok && v == "" && isBoolField(f.Type) {
+		v = "true"
+	}
+	return v, ok
+}
+
+// Sets a flag field given on the command line: count fields are
+// incremented, `flag` fields set to their flag value and bool fields set
+// to true
+func (a *App) setFlagField(sv reflect.Value, f reflect.StructField) error {
+	field := fieldValue(sv, f.Index)
+	if isCountField(f) {
+		field.SetInt(field.Int() + 1)
+		return nil
+	}
+	if v, ok := flagValue(f); ok {
+		return a.setField(sv, f, v)
+	}
+	setBoolField(field)
+	return nil
+}
+
 // Checks if a field is a boolean or pointer to boolean
 func isBoolField(fieldType reflect.Type) bool {
 	return fieldType.Kind() == reflect.Bool ||
 		(fieldType.Kind() == reflect.Ptr && fieldType.Elem().Kind() == reflect.Bool)
 }
 
-// Builds lookup maps for struct fields based on their tags
-func buildFieldMaps(t reflect.Type) (map[int]int, map[string]int, map[string]int) {
+// Lists the fields of struct type t in declaration order.
+// Each field's Index is its index path from t.
+//
+// Embedded structs (and struct pointers) are flattened: their fields are
+// listed in place of the embedded field, so a shared option set can be
+// embedded in several argument structs. Named struct fields are flattened
+// too, and their options are prefixed with the field name (--tls-cert for
+// TLS.Cert) unless the field has a `prefix` tag replacing it; `prefix:""`
+// drops it.
+func structFields(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := range t.NumField() {
+		f := t.Field(i)
+		st, ok := structParam(f.Type)
+		if !ok || parsesItself(f.Type) || !isOptionField(f) {
+			fields = append(fields, f)
+			continue
+		}
+
+		var prefix []string
+		if p, ok := f.Tag.Lookup("prefix"); ok {
+			prefix = strings.FieldsFunc(strings.ToLower(p), func(r rune) bool { return r == '-' || r == '_' || r == ' ' })
+		} else if !f.Anonymous {
+			prefix = splitWords(f.Name)
+		}
+		for _, sf := range structFields(st) {
+			sf.Index = append([]int{i}, sf.Index...)
+			if len(prefix) > 0 {
+				sf.Tag = withPrefix(sf.Tag, prefix)
+			}
+			fields = append(fields, sf)
+		}
+	}
+	return fields
+}
+
+// Records the option name prefix words of a nested struct field in its tag,
+// ahead of any prefix from deeper nesting
+func withPrefix(tag reflect.StructTag, prefix []string) reflect.StructTag {
+	words := prefix
+	if p, ok := tag.Lookup(prefixTag); ok {
+		words = append(slices.Clone(prefix), strings.Fields(p)...)
+		tag = reflect.StructTag(strings.Replace(string(tag), prefixTag+":"+strconv.Quote(p), "", 1))
+	}
+	return reflect.StructTag(strings.TrimSpace(string(tag)) + " " + prefixTag + ":" + strconv.Quote(strings.Join(words, " ")))
+}
+
+// Internal tag key holding the prefix words of a nested struct field
+const prefixTag = "cliapp-prefix"
+
+// Returns the field of sv at the index path, allocating nil struct pointers
+// along the way. Nested pointer structs are therefore only created once one
+// of their fields is set, and stay nil otherwise.
+func fieldValue(sv reflect.Value, index []int) reflect.Value {
+	v := sv
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// Reports whether the field at index lies in a nested struct pointer that
+// is still nil
+func inNilStruct(sv reflect.Value, index []int) bool {
+	v := sv
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return true
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return false
+}
+
+// Builds lookup maps for struct fields based on their tags.
+// Values are indexes into structFields(t).
+func (a *App) buildFieldMaps(t reflect.Type) (map[int]int, map[string]int, map[string]int) {
 	posFields := make(map[int]int) // position -> field index in struct
 	longMap := make(map[string]int)
 	shortMap := make(map[string]int)
 
-	for i := 0; i < t.NumField(); i++ {
-		f := t.Field(i)
+	for i, f := range structFields(t) {
 		tag := f.Tag
 
+		if _, ok := tag.Lookup("rest"); ok {
+			// rest captures leftovers and is never an option
+			continue
+		}
+
 		if v, ok := tag.Lookup("arg"); ok {
 			// parse integer for positional args
 			n, err := strconv.Atoi(v)
 			if err == nil {
 				posFields[n] = i
 			}
-		} else {
-			// no arg tag => default to named option with kebab-case long name
-			longName := "--" + toKebab(f.Name)
-			if v, ok := tag.Lookup("long"); ok {
-				longName = v
+		}
+
+		long, short := a.fieldOptionNames(f)
+		if long != "" {
+			longMap[long] = i
+		}
+		if short != "" {
+			shortMap[short] = i
+		}
+	}
+
+	return posFields, longMap, shortMap
+}
+
+// Returns the long and short option names a field is given by, either of
+// which may be empty. Positional fields only get the names their tags spell
+// out explicitly.
+func (a *App) fieldOptionNames(f reflect.StructField) (long, short string) {
+	if v, ok := f.Tag.Lookup("short"); ok {
+		short = normalizeShort(v)
+	}
+	if !hasLong(f) {
+		return "", short
+	}
+	if isOptionField(f) {
+		return a.optionName(f), short
+	}
+	if v, ok := f.Tag.Lookup("long"); ok && v != "" {
+		long = v
+	}
+	return long, short
+}
+
+// Returns every option name of a field: its long and short names and the
+// --no- form of a bool option
+func (a *App) allOptionNames(f reflect.StructField) []string {
+	var names []string
+	long, short := a.fieldOptionNames(f)
+	if long != "" {
+		names = append(names, long)
+		if isOptionField(f) && isBoolField(f.Type) {
+			names = append(names, negatedName(long))
+		}
+	}
+	if short != "" {
+		names = append(names, short)
+	}
+	return names
+}
+
+// Reports option names that two fields of struct t would both claim, which
+// buildFieldMaps would otherwise resolve by silently shadowing one of them.
+// Long names also may not take a long help flag such as --help.
+func (a *App) checkOptionNames(t reflect.Type) error {
+	fields := structFields(t)
+	owners := make(map[string]int)
+	for _, flag := range a.helpFlags() {
+		if strings.HasPrefix(flag, "--") {
+			owners[flag] = -1
+		}
+	}
+	for i, f := range fields {
+		for _, name := range a.allOptionNames(f) {
+			o, ok := owners[name]
+			switch {
+			case !ok:
+				owners[name] = i
+			case o < 0:
+				return fmt.Errorf("option %s of field %s collides with the built-in help option", name, f.Name)
+			default:
+				return fmt.Errorf("option %s is declared by both field %s and field %s", name, fields[o].Name, f.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// The option names declared by the struct parameters after the one being
+// parsed, mapped to whether the option takes a value. The earlier struct
+// passes these options on instead of reporting them as unknown.
+type laterOptions map[string]bool
+
+// Collects the options of the struct parameters in targs, or returns nil
+// when there are none
+func (a *App) laterOptions(targs []reflect.Type) laterOptions {
+	var l laterOptions
+	for _, t := range targs {
+		st, ok := structParam(t)
+		if !ok {
+			continue
+		}
+		if l == nil {
+			l = make(laterOptions)
+		}
+		for _, f := range structFields(st) {
+			for _, name := range a.allOptionNames(f) {
+				l[name] = !isFlagField(f)
 			}
-			longMap[longName] = i
+		}
+	}
+	return l
+}
 
-			if v, ok := tag.Lookup("short"); ok {
-				shortMap[v] = i
-			}
+// Returns the number of args spanned by the option at raw[0] when it is one
+// of l, and 0 otherwise. A value is spanned when it is attached (--name=val,
+// -ofile) or follows the option.
+func (l laterOptions) span(raw []string) int {
+	tok := raw[0]
+	if name, _, ok := strings.Cut(tok, "="); ok && strings.HasPrefix(tok, "--") {
+		if _, ok := l[name]; ok {
+			return 1
 		}
-
-		// if arg tag existed, still allow explicit long/short mapping
-		if v, ok := tag.Lookup("long"); ok {
-			longMap[v] = i
+		return 0
+	}
+	if takesValue, ok := l[tok]; ok {
+		if takesValue && len(raw) > 1 {
+			return 2
 		}
-		if v, ok := tag.Lookup("short"); ok {
-			shortMap[v] = i
+		return 1
+	}
+	if strings.HasPrefix(tok, "-") && !strings.HasPrefix(tok, "--") {
+		for name, takesValue := range l {
+			if takesValue && !strings.HasPrefix(name, "--") && len(name) < len(tok) && strings.HasPrefix(tok, name) {
+				return 1
+			}
 		}
 	}
-
-	return posFields, longMap, shortMap
+	return 0
 }
 
 // Parses command line args into a struct value of type t.
-// It returns the reflect.Value (addressable) and the number of raw args consumed.
+// It returns the reflect.Value (addressable), the number of raw args consumed
+// and the args passed on to the struct parameters after it.
+//
+// When later is not nil, the struct shares the command line with later
+// struct parameters: their options, and the positional args it has no field
+// for, are passed on in order instead of ending the scan, and a -- is left
+// for them together with everything after it.
 //
 // Supported tags on struct fields:
 //
 //   - `arg:"N"`  - positional argument index (0-based) relative to the remaining args
 //   - `long:"--name"` - long option name
 //   - `short:"-n"` - short option name
-//   - `flag` - boolean flag (no value required)
-func parseStructArgs(raw []string, t reflect.Type) (reflect.Value, int, error) {
+//   - `flag:"value"` - option taking no value that sets the field to the tag
+//     value when given; bool fields are flags anyway and default to true
+//   - `rest` - []string field receiving the args left after option scanning
+//     stops at the first positional arg it has no field for, or at a --,
+//     which is dropped; the args are kept verbatim for passing through
+//   - `arg:"rest"` - slice field collecting the positional args after the
+//     numbered positions; options may appear among them
+func (a *App) parseStructArgs(raw []string, t reflect.Type, later laterOptions) (reflect.Value, int, []string, error) {
 	if t.Kind() != reflect.Struct {
-		return reflect.Value{}, 0, errors.New("parseStructArgs: t must be struct")
+		return reflect.Value{}, 0, nil, errors.New("parseStructArgs: t must be struct")
 	}
 
 	// create a new struct value
 	sv := reflect.New(t).Elem()
 
 	// Build lookup tables for long/short options and positional fields
-	posFields, longMap, shortMap := buildFieldMaps(t)
+	fields := structFields(t)
+	posFields, longMap, shortMap := a.buildFieldMaps(t)
 
 	consumed := 0
-
-	// First handle positional args: collect by increasing position index
-	if len(posFields) > 0 {
-		// find max index
-		max := -1
-		for k := range posFields {
-			if k > max {
-				max = k
-			}
+	set := make(map[int]bool) // field index -> value given on the command line
+
+	negated := a.negatableFields(fields)
+	restArg, hasRestArg := restArgField(fields)
+	var passed, unknown []string
+	// unknown options are passed on to a later struct parameter, or
+	// collected when IgnoreUnknownOptions is set
+	skipUnknown := func(tok string) bool {
+		switch {
+		case later != nil:
+			passed = append(passed, tok)
+		case a.opts.IgnoreUnknownOptions:
+			unknown = append(unknown, tok)
+		default:
+			return false
 		}
-		// for positions 0..max, consume from raw accordingly
-		for p := 0; p <= max; p++ {
-			fi, ok := posFields[p]
-			if !ok {
-				// skip
-				continue
-			}
-			if consumed >= len(raw) {
-				return reflect.Value{}, consumed, fmt.Errorf("not enough positional args for struct: need position %d", p)
+		return true
+	}
+
+	// Scan raw args for options and flags; the other args bind to the
+	// positional fields in order, wherever they appear among the options
+	i := 0
+	for i < len(raw) {
+		tok := raw[i]
+		// -- ends option scanning; the args after it are taken verbatim
+		if tok == "--" {
+			if later != nil {
+				break
 			}
-			f := sv.Field(fi)
-			err := parseAndSetField(f, raw[consumed])
+			i++
+			n, err := a.fillPositionals(sv, fields, posFields, set, raw[i:])
+			i += n
 			if err != nil {
-				return reflect.Value{}, consumed, fmt.Errorf("failed to parse positional arg at position %d: %w", p, err)
+				return reflect.Value{}, i, nil, err
+			}
+			for hasRestArg && i < len(raw) {
+				if err := a.appendRestArg(sv, fields, restArg, set, raw[i]); err != nil {
+					return reflect.Value{}, i, nil, err
+				}
+				i++
 			}
-			consumed++
+			break
+		}
+		if n := later.span(raw[i:]); n > 0 {
+			passed = append(passed, raw[i:i+n]...)
+			i += n
+			continue
 		}
-	}
 
-	// Next, scan remaining raw args for long/short options and flags
-	i := consumed
-	for i < len(raw) {
-		tok := raw[i]
 		// long form --name or --name=val
 		if strings.HasPrefix(tok, "--") {
+			if a.opts.AllowAbbrev {
+				name, rest := tok, ""
+				if eq := strings.Index(tok, "="); eq != -1 {
+					name, rest = tok[:eq], tok[eq:]
+				}
+				full, err := expandLong(name, longMap, negated)
+				if err != nil {
+					return reflect.Value{}, consumed, nil, atOption(kindUnknownOption, name, err)
+				}
+				tok = full + rest
+			}
 			// split on =
 			if eq := strings.Index(tok, "="); eq != -1 {
 				name := tok[:eq]
 				val := tok[eq+1:]
+				if _, ok := longMap[name]; !ok {
+					if _, ok := negated[name]; ok {
+						return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, name, fmt.Errorf("option %s does not take a value", name))
+					}
+					if !skipUnknown(tok) {
+						return reflect.Value{}, consumed, nil, atOption(kindUnknownOption, name, &UnknownOptionError{Option: name})
+					}
+				}
 				if fi, ok := longMap[name]; ok {
-					f := sv.Field(fi)
-					err := parseAndSetField(f, val)
+					// bool flags accept an explicit value: --verbose=false
+					if fv := fieldValue(sv, fields[fi].Index); isBoolField(fv.Type()) {
+						b, err := strconv.ParseBool(val)
+						if err != nil {
+							return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, name, newParseError("", -1, name, val, fv.Type(), err, "invalid boolean value %q for option %s", val, name))
+						}
+						setBoolFieldTo(fv, b)
+						set[fi] = true
+						i++
+						continue
+					}
+					err := a.setField(sv, fields[fi], val)
 					if err != nil {
-						return reflect.Value{}, consumed, fmt.Errorf("failed to parse value for option %s: %w", name, err)
+						return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, name, newParseError("", -1, name, val, fields[fi].Type, err, "failed to parse value for option %s: %v", name, err))
 					}
+					set[fi] = true
 				}
 				i++
 				continue
@@ -717,91 +2865,654 @@ func parseStructArgs(raw []string, t reflect.Type) (reflect.Value, int, error) {
 			// separate value in next token
 			name := tok
 			if fi, ok := longMap[name]; ok {
-				f := sv.Field(fi)
-				ft := f.Type()
-				// flag handling: both bool and *bool should be treated as flags
-				if isBoolField(ft) {
-					setBoolField(f)
+				// flag handling: bool, *bool, count and `flag` fields take no value
+				if isFlagField(fields[fi]) {
+					if err := a.setFlagField(sv, fields[fi]); err != nil {
+						return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, name, err)
+					}
+					set[fi] = true
 					i++
 					continue
 				}
 				if i+1 >= len(raw) {
-					return reflect.Value{}, consumed, fmt.Errorf("missing value for %s", name)
+					return reflect.Value{}, consumed, nil, atOption(kindMissingValue, name, &MissingValueError{Option: name})
 				}
-				err := parseAndSetField(f, raw[i+1])
+				err := a.setField(sv, fields[fi], raw[i+1])
 				if err != nil {
-					return reflect.Value{}, consumed, fmt.Errorf("failed to parse value for option %s: %w", name, err)
+					return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, name, newParseError("", -1, name, raw[i+1], fields[fi].Type, err, "failed to parse value for option %s: %v", name, err))
 				}
+				set[fi] = true
 				i += 2
 				continue
 			}
+			if fi, ok := negated[name]; ok {
+				setBoolFieldTo(fieldValue(sv, fields[fi].Index), false)
+				set[fi] = true
+				i++
+				continue
+			}
 			// unknown long option: error
-			return reflect.Value{}, consumed, fmt.Errorf("unknown option: %s", tok)
+			if skipUnknown(tok) {
+				i++
+				continue
+			}
+			return reflect.Value{}, consumed, nil, atOption(kindUnknownOption, tok, &UnknownOptionError{Option: tok})
 		}
 
-		// short form -x (maybe combined like -ab not supported) or -o val
-		if strings.HasPrefix(tok, "-") && len(tok) >= 2 {
+		// short form -x, -o val, or combined like -abc and -ofile.
+		// Negative numbers are values unless a short option has that name.
+		_, isShort := shortMap[tok]
+		if strings.HasPrefix(tok, "-") && len(tok) >= 2 && (isShort || !isNegativeNumber(tok)) {
 			// treat as short option key exactly as given
 			if fi, ok := shortMap[tok]; ok {
-				f := sv.Field(fi)
-				ft := f.Type()
-				// flag handling for short options as well (bool and *bool)
-				if isBoolField(ft) {
-					setBoolField(f)
+				// flag handling for short options as well
+				if isFlagField(fields[fi]) {
+					if err := a.setFlagField(sv, fields[fi]); err != nil {
+						return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, tok, err)
+					}
+					set[fi] = true
 					i++
 					continue
 				}
 				if i+1 >= len(raw) {
-					return reflect.Value{}, consumed, fmt.Errorf("missing value for %s", tok)
+					return reflect.Value{}, consumed, nil, atOption(kindMissingValue, tok, &MissingValueError{Option: tok})
 				}
-				err := parseAndSetField(f, raw[i+1])
+				err := a.setField(sv, fields[fi], raw[i+1])
 				if err != nil {
-					return reflect.Value{}, consumed, fmt.Errorf("failed to parse value for option %s: %w", tok, err)
+					return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, tok, newParseError("", -1, tok, raw[i+1], fields[fi].Type, err, "failed to parse value for option %s: %v", tok, err))
 				}
+				set[fi] = true
 				i += 2
 				continue
 			}
+			// attached value: -ofoo.txt, or -np4 for a multi-character -np
+			if fi, key, ok := attachedShort(tok, shortMap, fields); ok {
+				if err := a.setField(sv, fields[fi], tok[len(key):]); err != nil {
+					return reflect.Value{}, consumed, nil, atOption(kindInvalidValue, key, newParseError("", -1, key, tok[len(key):], fields[fi].Type, err, "failed to parse value for option %s: %v", key, err))
+				}
+				set[fi] = true
+				i++
+				continue
+			}
+			if utf8.RuneCountInString(tok) > 2 {
+				n, err := a.parseShortCluster(sv, fields, shortMap, set, raw[i:])
+				if err != nil {
+					if errors.Is(err, ErrUnknownOption) && skipUnknown(tok) {
+						i++
+						continue
+					}
+					return reflect.Value{}, consumed, nil, err
+				}
+				i += n
+				continue
+			}
 			// unknown short option: error
-			return reflect.Value{}, consumed, fmt.Errorf("unknown option: %s", tok)
+			if skipUnknown(tok) {
+				i++
+				continue
+			}
+			return reflect.Value{}, consumed, nil, atOption(kindUnknownOption, tok, &UnknownOptionError{Option: tok})
+		}
+
+		// positional: bound to the next unset positional field; leftovers
+		// are collected by an arg:"rest" field, or passed on to a later
+		// struct parameter; otherwise option scanning stops
+		bound, err := a.bindPositional(sv, fields, posFields, set, tok)
+		if err != nil {
+			return reflect.Value{}, i, nil, err
+		}
+		if bound {
+			i++
+			continue
+		}
+		if !hasRestArg {
+			if later == nil {
+				break
+			}
+			passed = append(passed, tok)
+			i++
+			continue
 		}
+		if err := a.appendRestArg(sv, fields, restArg, set, tok); err != nil {
+			return reflect.Value{}, i, nil, err
+		}
+		i++
+	}
+	consumed = i
+	a.warnDeprecated(fields, set)
+	if err := a.checkExclusive(fields, set); err != nil {
+		return reflect.Value{}, consumed, nil, err
+	}
+
+	if err := a.applyEnv(sv, fields, set); err != nil {
+		return reflect.Value{}, consumed, nil, err
+	}
+	if err := a.applyConfig(sv, fields, set); err != nil {
+		return reflect.Value{}, consumed, nil, err
+	}
+	// missing positionals are reported together with missing required options
+	missingArgs := a.applyPositionalRules(sv, fields, posFields, set)
+	if missingArgs != nil && !errors.Is(missingArgs, ErrWrongArgCount) {
+		return reflect.Value{}, consumed, nil, missingArgs
+	}
+	if err := a.applyOptionDefaults(sv, fields, set); err != nil {
+		return reflect.Value{}, consumed, nil, err
+	}
+	if err := a.checkRequiredOptions(sv, fields, set); err != nil {
+		if missingArgs != nil {
+			return reflect.Value{}, consumed, nil, fmt.Errorf("%w; %v", missingArgs, err)
+		}
+		return reflect.Value{}, consumed, nil, err
+	}
+	if missingArgs != nil {
+		return reflect.Value{}, consumed, nil, missingArgs
+	}
+	if err := a.applyOptionRules(fields, longMap, set); err != nil {
+		return reflect.Value{}, consumed, nil, err
+	}
 
-		// positional leftover without explicit tag: stop scanning options
-		break
+	if fi, ok := restField(fields); ok {
+		f := fieldValue(sv, fields[fi].Index)
+		f.Set(reflect.ValueOf(append(append([]string{}, unknown...), raw[consumed:]...)))
+		consumed = len(raw)
 	}
 
-	return sv, consumed, nil
+	return sv, consumed, passed, nil
 }
 
-// Converts CamelCase/PascalCase to space-separated lowercase words.
-//   - FilePath -> file path
-func toWords(s string) string {
-	var b strings.Builder
-	for i, r := range s {
-		if unicode.IsUpper(r) {
-			if i > 0 {
-				b.WriteByte(' ')
+// Reports whether missing values may be read interactively
+func (a *App) canPrompt() bool {
+	return a.opts.InteractiveMissing && isTerminal(a.opts.Input)
+}
+
+// Writes a prompt to Log and reads one line from Input
+func (a *App) prompt(label string) (string, error) {
+	if a.input == nil {
+		a.input = bufio.NewReader(a.opts.Input)
+	}
+	fmt.Fprintf(a.opts.Log, "%s: ", label)
+	line, err := a.input.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("failed to read %s: %w", label, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Returns the prompt label of a field: its help text or its name in words
+func fieldPrompt(f reflect.StructField) string {
+	if d, ok := f.Tag.Lookup("help"); ok && d != "" {
+		return d
+	}
+	return toWords(f.Name)
+}
+
+// Reports whether r is an interactive terminal (overridable in tests)
+var isTerminal = func(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Fills fields that were not given on the command line from the environment
+// variable named by their `env` tag. The command line wins over the
+// environment, which wins over defaults.
+func (a *App) applyEnv(sv reflect.Value, fields []reflect.StructField, set map[int]bool) error {
+	for i, f := range fields {
+		if set[i] {
+			continue
+		}
+		name, ok := f.Tag.Lookup("env")
+		if !ok || name == "" {
+			continue
+		}
+		val, ok := a.opts.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := a.setField(sv, f, val); err != nil {
+			return fmt.Errorf("failed to parse environment variable %s for %s: %w", name, f.Name, err)
+		}
+		set[i] = true
+	}
+	return nil
+}
+
+// Fills options that were not given on the command line from their
+// `default` tag, and enables toggles that were not switched off by their
+// --no- form. Options without a default keep their zero value, so pointer
+// fields stay nil, and so do nested struct pointers none of whose options
+// were given.
+func (a *App) applyOptionDefaults(sv reflect.Value, fields []reflect.StructField, set map[int]bool) error {
+	for i, f := range fields {
+		if set[i] || !isOptionField(f) || inNilStruct(sv, f.Index) {
+			continue
+		}
+		if _, ok := f.Tag.Lookup("toggle"); ok {
+			fieldValue(sv, f.Index).SetBool(true)
+		}
+		def, ok := f.Tag.Lookup("default")
+		if !ok {
+			continue
+		}
+		if err := a.setField(sv, f, def); err != nil {
+			return fmt.Errorf("invalid default %q for option %s: %w", def, a.optionName(f), err)
+		}
+	}
+	return nil
+}
+
+// Reports an error listing every option tagged `required:"true"` that was
+// not given and has no default. Missing options are prompted for instead
+// when InteractiveMissing is enabled and the input is a terminal.
+func (a *App) checkRequiredOptions(sv reflect.Value, fields []reflect.StructField, set map[int]bool) error {
+	var missing []string
+	for i, f := range fields {
+		if set[i] || !isOptionField(f) || !isRequired(f) {
+			continue
+		}
+		if _, ok := f.Tag.Lookup("default"); ok {
+			continue
+		}
+		name := a.optionName(f)
+		if a.canPrompt() {
+			val, err := a.prompt(fieldPrompt(f))
+			if err != nil {
+				return err
+			}
+			if err := a.setField(sv, f, val); err != nil {
+				return atOption(kindInvalidValue, name, newParseError("", -1, name, val, f.Type, err, "failed to parse value for option %s: %v", name, err))
+			}
+			set[i] = true
+			continue
+		}
+		missing = append(missing, name)
+	}
+	switch len(missing) {
+	case 0:
+		return nil
+	case 1:
+		return atOption(kindMissingRequired, missing[0], fmt.Errorf("missing required option: %s", missing[0]))
+	default:
+		return atOption(kindMissingRequired, missing[0], fmt.Errorf("missing required options: %s", strings.Join(missing, ", ")))
+	}
+}
+
+// Reports whether a field is tagged `required:"true"`
+func isRequired(f reflect.StructField) bool {
+	v, ok := f.Tag.Lookup("required")
+	if !ok {
+		return false
+	}
+	required, err := strconv.ParseBool(v)
+	return err == nil && required
+}
+
+// Reports whether a field is a named option rather than a positional or rest field
+func isOptionField(f reflect.StructField) bool {
+	if _, ok := f.Tag.Lookup("arg"); ok {
+		return false
+	}
+	if _, ok := f.Tag.Lookup("rest"); ok {
+		return false
+	}
+	return true
+}
+
+// Checks cross-field constraints between the options given on the command line.
+//
+// A field tagged `only-with:"--other"` may only be given together with --other.
+func (a *App) applyOptionRules(fields []reflect.StructField, longMap map[string]int, set map[int]bool) error {
+	for i, f := range fields {
+		if !set[i] {
+			continue
+		}
+		other, ok := f.Tag.Lookup("only-with")
+		if !ok {
+			continue
+		}
+		if oi := longMap[other]; !set[oi] {
+			return atOption(kindValidation, a.optionName(f), fmt.Errorf("%s requires %s", a.optionName(f), other))
+		}
+	}
+	return nil
+}
+
+// Expands an abbreviated long option name to the single option it is a
+// prefix of. Exact and unknown names are returned unchanged.
+func expandLong(name string, longMap, negated map[string]int) (string, error) {
+	_, ok := longMap[name]
+	if _, neg := negated[name]; ok || neg {
+		return name, nil
+	}
+	var matches []string
+	for _, m := range []map[string]int{longMap, negated} {
+		for long := range m {
+			if strings.HasPrefix(long, name) {
+				matches = append(matches, long)
 			}
-			b.WriteRune(unicode.ToLower(r))
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return name, nil
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", fmt.Errorf("ambiguous option: %s could be %s", name, strings.Join(matches, ", "))
+}
+
+// Returns the --no- option names of all bool option fields
+func (a *App) negatableFields(fields []reflect.StructField) map[string]int {
+	negated := make(map[string]int)
+	for i, f := range fields {
+		if isOptionField(f) && isBoolField(f.Type) && hasLong(f) {
+			negated[negatedName(a.optionName(f))] = i
+		}
+	}
+	return negated
+}
+
+// Reports whether an option has a long form; `long:"-"` leaves only its
+// short form
+func hasLong(f reflect.StructField) bool {
+	return f.Tag.Get("long") != "-"
+}
+
+// Returns the --no- form of a long option name
+//   - --cache -> --no-cache
+func negatedName(long string) string {
+	return "--no-" + strings.TrimPrefix(long, "--")
+}
+
+// Returns a short option name with its leading dash
+//   - v -> -v
+func normalizeShort(v string) string {
+	if strings.HasPrefix(v, "-") {
+		return v
+	}
+	return "-" + v
+}
+
+// Returns the long option name of a struct field
+func (a *App) optionName(f reflect.StructField) string {
+	if !hasLong(f) {
+		return normalizeShort(f.Tag.Get("short"))
+	}
+	if p, ok := f.Tag.Lookup(prefixTag); ok {
+		words := strings.Fields(p)
+		if v, ok := f.Tag.Lookup("long"); ok && v != "" {
+			words = append(words, strings.TrimPrefix(v, "--"))
 		} else {
-			b.WriteRune(r)
+			words = append(words, splitWords(f.Name)...)
+		}
+		return "--" + a.joinWords(words)
+	}
+	if v, ok := f.Tag.Lookup("long"); ok && v != "" {
+		return v
+	}
+	switch a.opts.FlagCase {
+	case FlagCaseSnake:
+		return "--" + toSnake(f.Name)
+	case FlagCaseCamel:
+		return "--" + toCamel(f.Name)
+	case FlagCaseLower:
+		return "--" + strings.ToLower(f.Name)
+	default:
+		return "--" + toKebab(f.Name)
+	}
+}
+
+// Returns the index of the field tagged arg:"rest"
+func restArgField(fields []reflect.StructField) (int, bool) {
+	for i, f := range fields {
+		if f.Tag.Get("arg") == "rest" {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Appends a leftover positional arg to the arg:"rest" field
+func (a *App) appendRestArg(sv reflect.Value, fields []reflect.StructField, fi int, set map[int]bool, tok string) error {
+	if err := a.setField(sv, fields[fi], tok); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", toKebab(fields[fi].Name), err)
+	}
+	set[fi] = true
+	return nil
+}
+
+// Returns the index of the field tagged `rest`, if any
+func restField(fields []reflect.StructField) (int, bool) {
+	for i, f := range fields {
+		if _, ok := f.Tag.Lookup("rest"); ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Finds the longest short option name that prefixes tok and takes a value,
+// so that the rest of tok is its attached value. Bool flags never take an
+// attached value.
+func attachedShort(tok string, shortMap map[string]int, fields []reflect.StructField) (int, string, bool) {
+	best, bestKey := -1, ""
+	for key, fi := range shortMap {
+		if len(key) <= len(bestKey) || len(key) >= len(tok) || !strings.HasPrefix(tok, key) {
+			continue
+		}
+		if isFlagField(fields[fi]) {
+			continue
+		}
+		best, bestKey = fi, key
+	}
+	return best, bestKey, best >= 0
+}
+
+// Assigns args to the positional fields that are still unset, in position
+// order. Returns the number of args consumed; missing positionals are
+// resolved after scanning.
+func (a *App) fillPositionals(sv reflect.Value, fields []reflect.StructField, posFields map[int]int, set map[int]bool, raw []string) (int, error) {
+	for n, tok := range raw {
+		bound, err := a.bindPositional(sv, fields, posFields, set, tok)
+		if err != nil || !bound {
+			return n, err
 		}
 	}
-	return b.String()
+	return len(raw), nil
+}
+
+// Assigns tok to the unset positional field with the lowest position.
+// Reports false when every positional field is already set.
+func (a *App) bindPositional(sv reflect.Value, fields []reflect.StructField, posFields map[int]int, set map[int]bool, tok string) (bool, error) {
+	p, fi := -1, 0
+	for pos, i := range posFields {
+		if !set[i] && (p < 0 || pos < p) {
+			p, fi = pos, i
+		}
+	}
+	if p < 0 {
+		return false, nil
+	}
+	if err := a.setField(sv, fields[fi], tok); err != nil {
+		return false, atPosition(kindInvalidValue, p, newParseError("", p, "", tok, fields[fi].Type, err, "failed to parse positional arg at position %d: %v", p, err))
+	}
+	set[fi] = true
+	return true, nil
+}
+
+// Parses a cluster of single-character short options such as -abc, meaning
+// -a -b -c. A non-bool option takes the rest of the cluster as its value
+// (-ofile), or the next argument when it ends the cluster (-vo file).
+// Returns the number of args consumed from raw, whose first element is the
+// cluster.
+func (a *App) parseShortCluster(sv reflect.Value, fields []reflect.StructField, shortMap map[string]int, set map[int]bool, raw []string) (int, error) {
+	tok := raw[0]
+	cluster := tok[1:]
+	for j, r := range cluster {
+		name := "-" + string(r)
+		fi, ok := shortMap[name]
+		if !ok {
+			return 0, atOption(kindUnknownOption, name, &UnknownOptionError{Option: name, Cluster: tok})
+		}
+		if isFlagField(fields[fi]) {
+			if err := a.setFlagField(sv, fields[fi]); err != nil {
+				return 0, atOption(kindInvalidValue, name, err)
+			}
+			set[fi] = true
+			continue
+		}
+
+		value := cluster[j+utf8.RuneLen(r):]
+		n := 1
+		if value == "" {
+			if len(raw) < 2 {
+				return 0, atOption(kindMissingValue, name, &MissingValueError{Option: name})
+			}
+			value = raw[1]
+			n = 2
+		}
+		if err := a.setField(sv, fields[fi], value); err != nil {
+			return 0, atOption(kindInvalidValue, name, newParseError("", -1, name, value, fields[fi].Type, err, "failed to parse value for option %s: %v", name, err))
+		}
+		set[fi] = true
+		return n, nil
+	}
+	return 1, nil
+}
+
+// Resolves positional fields that were not given on the command line.
+//
+// A missing positional is filled from its `default` tag when present.
+// Otherwise it is prompted for when InteractiveMissing is enabled and the
+// input is a terminal, and reported missing unless its `required` tag is
+// false. The first missing positional is reported.
+func (a *App) applyPositionalRules(sv reflect.Value, fields []reflect.StructField, posFields map[int]int, set map[int]bool) error {
+	positions := make([]int, 0, len(posFields))
+	for p := range posFields {
+		positions = append(positions, p)
+	}
+	sort.Ints(positions)
+
+	for _, p := range positions {
+		fi := posFields[p]
+		if set[fi] {
+			continue
+		}
+		f := fields[fi]
+		tag := f.Tag
+		if def, ok := tag.Lookup("default"); ok {
+			if err := a.setField(sv, f, def); err != nil {
+				return fmt.Errorf("invalid default for positional arg at position %d: %w", p, err)
+			}
+			continue
+		}
+		if _, ok := tag.Lookup("required"); ok && !isRequired(f) {
+			continue
+		}
+		if a.canPrompt() {
+			val, err := a.prompt(fieldPrompt(f))
+			if err != nil {
+				return err
+			}
+			if err := a.setField(sv, f, val); err != nil {
+				return atPosition(kindInvalidValue, p, newParseError("", p, "", val, f.Type, err, "failed to parse positional arg at position %d: %v", p, err))
+			}
+			continue
+		}
+		return atPosition(kindWrongArgCount, p, fmt.Errorf("missing argument: %s", positionalName(f)))
+	}
+	return nil
+}
+
+// Returns the name a positional field is reported by: its help text, or its
+// field name in kebab case
+//   - InputFile -> input-file
+func positionalName(f reflect.StructField) string {
+	if d, ok := f.Tag.Lookup("help"); ok && d != "" {
+		return d
+	}
+	return toKebab(f.Name)
+}
+
+// Splits a CamelCase/PascalCase name into lowercase words, keeping acronyms
+// together.
+//   - OutDir -> [out dir]
+//   - HTTPPort -> [http port]
+func splitWords(s string) []string {
+	rs := []rune(s)
+	var words []string
+	start := 0
+	for i := 1; i < len(rs); i++ {
+		if !unicode.IsUpper(rs[i]) {
+			continue
+		}
+		// boundary before an upper-case rune that follows a lower-case one,
+		// or that starts a new word after an acronym (the P in HTTPPort)
+		prevLower := !unicode.IsUpper(rs[i-1])
+		nextLower := i+1 < len(rs) && unicode.IsLower(rs[i+1])
+		if prevLower || nextLower {
+			words = append(words, strings.ToLower(string(rs[start:i])))
+			start = i
+		}
+	}
+	if start < len(rs) {
+		words = append(words, strings.ToLower(string(rs[start:])))
+	}
+	return words
+}
+
+// Joins lower-case words in the configured FlagCase
+//   - [tls cert] -> tls-cert, tls_cert, tlsCert or tlscert
+func (a *App) joinWords(words []string) string {
+	switch a.opts.FlagCase {
+	case FlagCaseSnake:
+		return strings.Join(words, "_")
+	case FlagCaseCamel:
+		words = slices.Clone(words)
+		for i := 1; i < len(words); i++ {
+			r, size := utf8.DecodeRuneInString(words[i])
+			words[i] = string(unicode.ToUpper(r)) + words[i][size:]
+		}
+		return strings.Join(words, "")
+	case FlagCaseLower:
+		return strings.Join(words, "")
+	default:
+		return strings.Join(words, "-")
+	}
+}
+
+// Converts CamelCase/PascalCase to space-separated lowercase words.
+//   - FilePath -> file path
+func toWords(s string) string {
+	return strings.Join(splitWords(s), " ")
 }
 
 // Converts CamelCase/PascalCase to kebab-case
 //   - OutDir -> out-dir
 func toKebab(s string) string {
-	var b strings.Builder
-	for i, r := range s {
-		if unicode.IsUpper(r) {
-			if i > 0 {
-				b.WriteByte('-')
-			}
-			b.WriteRune(unicode.ToLower(r))
-		} else {
-			b.WriteRune(r)
-		}
+	return strings.Join(splitWords(s), "-")
+}
+
+// Converts CamelCase/PascalCase to snake_case
+//   - OutDir -> out_dir
+func toSnake(s string) string {
+	return strings.Join(splitWords(s), "_")
+}
+
+// Converts CamelCase/PascalCase to camelCase
+//   - OutDir -> outDir
+func toCamel(s string) string {
+	words := splitWords(s)
+	for i := 1; i < len(words); i++ {
+		r, size := utf8.DecodeRuneInString(words[i])
+		words[i] = string(unicode.ToUpper(r)) + words[i][size:]
 	}
-	return b.String()
+	return strings.Join(words, "")
 }
@@ -2,8 +2,19 @@ package cliapp
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAddCommand(t *testing.T) {
@@ -62,3 +73,3026 @@ func TestStructArgParsing(t *testing.T) {
 		t.Fatalf("expected UseMarkdown true, got %v", got.UseMarkdown)
 	}
 }
+
+func TestFlagTag(t *testing.T) {
+	type LogArgs struct {
+		Level   string   `flag:"debug" short:"d" default:"info"`
+		Quiet   *int     `flag:"0"`
+		Tags    []string `flag:"all"`
+		Color   bool     `flag:"false" default:"true"`
+		Message string   `arg:"0"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) { got = a })
+
+	if err := app.Run("log", "hi"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Level != "info" || got.Quiet != nil || got.Tags != nil || !got.Color {
+		t.Fatalf("unexpected defaults: %+v", got)
+	}
+
+	// flags take no value: "hi" after -d is not its value
+	if err := app.Run("log", "hi", "--quiet", "--tags", "-d", "--color"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Level != "debug" || got.Quiet == nil || *got.Quiet != 0 || !reflect.DeepEqual(got.Tags, []string{"all"}) || got.Color {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// an explicit value still overrides the flag value
+	if err := app.Run("log", "hi", "--level=warn"); err != nil || got.Level != "warn" {
+		t.Fatalf("unexpected level %q: %v", got.Level, err)
+	}
+
+	type BadArgs struct {
+		Level string `flag:""`
+	}
+	type BadValueArgs struct {
+		Count int `flag:"many"`
+	}
+	for _, fn := range []any{func(a BadArgs) {}, func(a BadValueArgs) {}} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "flag") {
+					t.Fatalf("expected panic for invalid flag tag, got %v", r)
+				}
+			}()
+			New(Options{ExitOnError: false}).Add("log", fn)
+		}()
+	}
+}
+
+func TestInterleavedPositionals(t *testing.T) {
+	type CopyArgs struct {
+		Src   string   `arg:"0"`
+		Dst   string   `arg:"1" default:"out.txt"`
+		Out   string   `short:"o"`
+		Force bool     `short:"f"`
+		Rest  []string `rest:""`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got CopyArgs
+	app.Add("copy", func(a CopyArgs) { got = a })
+
+	for _, args := range [][]string{
+		{"copy", "--out", "x", "a.txt", "b.txt", "-f"},
+		{"copy", "-f", "a.txt", "-o", "x", "b.txt"},
+		{"copy", "a.txt", "-ox", "-f", "b.txt"},
+	} {
+		got = CopyArgs{}
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+		if got.Src != "a.txt" || got.Dst != "b.txt" || got.Out != "x" || !got.Force || len(got.Rest) != 0 {
+			t.Fatalf("Run(%q): unexpected args: %+v", args, got)
+		}
+	}
+
+	// positionals left unset fall back to their defaults, and args past the
+	// last position end option scanning
+	if err := app.Run("copy", "--force", "a.txt"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Src != "a.txt" || got.Dst != "out.txt" || !got.Force {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if err := app.Run("copy", "-f", "a.txt", "b.txt", "c.txt", "-o", "x"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Out != "" || !reflect.DeepEqual(got.Rest, []string{"c.txt", "-o", "x"}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+}
+
+func TestPositionalDefaultAndRequired(t *testing.T) {
+	type CopyArgs struct {
+		Src  string `arg:"0"`
+		Dst  string `arg:"1" default:"out.txt"`
+		Mode string `arg:"2" required:"false"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got CopyArgs
+	app.Add("copy", func(a CopyArgs) {
+		got = a
+	})
+
+	if err := app.Run("copy", "in.txt"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Src != "in.txt" || got.Dst != "out.txt" || got.Mode != "" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("copy", "in.txt", "dst.txt", "fast"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Dst != "dst.txt" || got.Mode != "fast" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("copy"); err == nil {
+		t.Fatalf("expected error for missing required positional")
+	}
+
+	// defaults are resolved like given values
+	env := New(Options{ExitOnError: false, LookupEnv: func(key string) (string, bool) {
+		return "in.txt", key == "SRC"
+	}})
+	var src string
+	env.Add("cat", func(a struct {
+		Src string `arg:"0" default:"@env:SRC" indirect:""`
+	}) {
+		src = a.Src
+	})
+	if err := env.Run("cat"); err != nil || src != "in.txt" {
+		t.Fatalf("expected indirect positional default, got %q, %v", src, err)
+	}
+}
+
+func TestMissingPositionalMessage(t *testing.T) {
+	type ConvertArgs struct {
+		InputFile string `arg:"0"`
+		Format    string `arg:"1" help:"output format"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("convert", func(a ConvertArgs) {})
+
+	err := app.Run("convert")
+	if !errors.Is(err, ErrWrongArgCount) || !strings.HasSuffix(err.Error(), "missing argument: input-file") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = app.Run("convert", "in.md")
+	if !errors.Is(err, ErrWrongArgCount) || !strings.HasSuffix(err.Error(), "missing argument: output format") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// missing required options are reported along with the positional
+	type UploadArgs struct {
+		File   string `arg:"0"`
+		Bucket string `required:"true"`
+	}
+	app.Add("upload", func(a UploadArgs) {})
+	err = app.Run("upload")
+	if !errors.Is(err, ErrWrongArgCount) || !strings.HasSuffix(err.Error(), "missing argument: file; missing required option: --bucket") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// required is parsed as a bool, as for options
+	type ListArgs struct {
+		Dir  string `arg:"0" required:"0"`
+		Glob string `arg:"1" required:"F"`
+	}
+	app.Add("list", func(a ListArgs) {})
+	if err := app.Run("list"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+}
+
+func TestOptionalPositionalOrder(t *testing.T) {
+	type MoveArgs struct {
+		Src string `arg:"0" default:"."`
+		Dst string `arg:"1"`
+	}
+
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), "required positional dst at position 1") {
+			t.Fatalf("expected panic for required positional after optional, got %v", r)
+		}
+	}()
+	New(Options{ExitOnError: false}).Add("move", func(a MoveArgs) {})
+}
+
+func TestVersion(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, Version: "v1.2.3"})
+	if err := app.Run("--version"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got := buf.String(); got != "v1.2.3\n" {
+		t.Fatalf("expected version output, got %q", got)
+	}
+}
+
+func TestSetVersion(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("build", func() {})
+
+	if err := app.Run("version"); err == nil {
+		t.Fatalf("expected unknown command error without a version")
+	}
+
+	app.SetVersion("v2.0.0")
+	for _, arg := range []string{"--version", "-v", "version"} {
+		buf.Reset()
+		if err := app.Run(arg); err != nil {
+			t.Fatalf("Run(%q) failed: %v", arg, err)
+		}
+		if got := buf.String(); got != "v2.0.0\n" {
+			t.Fatalf("Run(%q): expected version output, got %q", arg, got)
+		}
+	}
+
+	buf.Reset()
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), app.programName()+" v2.0.0\n\n") || !strings.Contains(buf.String(), "-v|--version") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	// a registered version command takes precedence
+	called := false
+	app.Add("version", func() { called = true })
+	if err := app.Run("version"); err != nil || !called {
+		t.Fatalf("expected registered version command to run: called=%v err=%v", called, err)
+	}
+}
+
+func TestBuildInfoVersion(t *testing.T) {
+	orig := readBuildInfo
+	defer func() { readBuildInfo = orig }()
+	readBuildInfo = func() (*debug.BuildInfo, bool) {
+		return &debug.BuildInfo{
+			GoVersion: "go1.22.4",
+			Main:      debug.Module{Path: "example.com/tool", Version: "v0.1.0"},
+			Settings: []debug.BuildSetting{
+				{Key: "vcs.revision", Value: "abc123"},
+				{Key: "vcs.time", Value: "2024-01-02T03:04:05Z"},
+			},
+		}, true
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, BuildInfoVersion: true})
+	if err := app.Run("--version"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "example.com/tool v0.1.0\ngo: go1.22.4\nrevision: abc123\ntime: 2024-01-02T03:04:05Z\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestStructRestField(t *testing.T) {
+	type ExecArgs struct {
+		Name    string   `arg:"0"`
+		Verbose bool     `short:"-v"`
+		Rest    []string `rest:""`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got ExecArgs
+	app.Add("exec", func(a ExecArgs) {
+		got = a
+	})
+
+	if err := app.Run("exec", "tool", "-v", "a", "b"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Name != "tool" || !got.Verbose {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if len(got.Rest) != 2 || got.Rest[0] != "a" || got.Rest[1] != "b" {
+		t.Fatalf("expected Rest [a b], got %v", got.Rest)
+	}
+
+	// options after the first leftover are passed through untouched
+	if err := app.Run("exec", "tool", "ls", "-la", "--color=auto", "-v"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Verbose || !reflect.DeepEqual(got.Rest, []string{"ls", "-la", "--color=auto", "-v"}) {
+		t.Fatalf("unexpected pass-through args: %+v", got)
+	}
+}
+
+func TestIgnoreUnknownOptions(t *testing.T) {
+	type ExecArgs struct {
+		Name    string   `arg:"0"`
+		Verbose bool     `short:"-v"`
+		Rest    []string `rest:""`
+	}
+	type BuildArgs struct {
+		Race bool
+	}
+
+	strict := New(Options{ExitOnError: false})
+	strict.Add("exec", func(a ExecArgs) {})
+	for _, args := range [][]string{{"exec", "tool", "--color"}, {"exec", "tool", "--color=auto"}, {"exec", "tool", "-x"}} {
+		if err := strict.Run(args...); !errors.Is(err, ErrUnknownOption) {
+			t.Fatalf("Run(%q): expected unknown option error, got %v", args, err)
+		}
+	}
+
+	app := New(Options{ExitOnError: false, IgnoreUnknownOptions: true})
+	var got ExecArgs
+	app.Add("exec", func(a ExecArgs) { got = a })
+	var build BuildArgs
+	app.Add("build", func(a BuildArgs) { build = a })
+	var echoed []string
+	app.Add("echo", func(s ...string) { echoed = s })
+
+	if err := app.Run("exec", "tool", "--color=auto", "-v", "-xz", "--", "a", "-b"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Name != "tool" || !got.Verbose || !reflect.DeepEqual(got.Rest, []string{"--color=auto", "-xz", "a", "-b"}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("build", "--race", "--trimpath"); err != nil || !build.Race {
+		t.Fatalf("unexpected result: %+v err=%v", build, err)
+	}
+
+	if err := app.Run("echo", "a", "--loud", "b"); err != nil || !reflect.DeepEqual(echoed, []string{"a", "b"}) {
+		t.Fatalf("unexpected result: %v err=%v", echoed, err)
+	}
+}
+
+func TestStrictArgs(t *testing.T) {
+	type ExecArgs struct {
+		Name string `arg:"0"`
+	}
+
+	app := New(Options{ExitOnError: false, StrictArgs: true})
+	app.Add("exec", func(a ExecArgs) {})
+
+	if err := app.Run("exec", "tool"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := app.Run("exec", "tool", "extra"); err == nil {
+		t.Fatalf("expected error for leftover args")
+	}
+}
+
+func TestOnlyWithOption(t *testing.T) {
+	type PackArgs struct {
+		Compress         bool
+		CompressionLevel int `only-with:"--compress"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got PackArgs
+	app.Add("pack", func(a PackArgs) {
+		got = a
+	})
+
+	if err := app.Run("pack", "--compress", "--compression-level", "9"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Compress || got.CompressionLevel != 9 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("pack", "--compression-level", "9")
+	if err == nil {
+		t.Fatalf("expected error when --compress is missing")
+	}
+	if !strings.Contains(err.Error(), "--compression-level requires --compress") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOnParsedHook(t *testing.T) {
+	type GreetArgs struct {
+		Name string `arg:"0"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got GreetArgs
+	app.Add("greet", func(a GreetArgs) {
+		got = a
+	})
+
+	var command string
+	app.OnParsed(func(cmd string, v reflect.Value) error {
+		command = cmd
+		f := v.FieldByName("Name")
+		f.SetString(strings.ToUpper(f.String()))
+		return nil
+	})
+
+	if err := app.Run("greet", "bob"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if command != "greet" || got.Name != "BOB" {
+		t.Fatalf("unexpected result: command=%q args=%+v", command, got)
+	}
+
+	app.OnParsed(func(cmd string, v reflect.Value) error {
+		return errors.New("rejected")
+	})
+	got = GreetArgs{}
+	if err := app.Run("greet", "bob"); err == nil || err.Error() != "rejected" {
+		t.Fatalf("expected hook error, got %v", err)
+	}
+	if got.Name != "" {
+		t.Fatalf("handler should not run when a hook fails")
+	}
+}
+
+func TestToggleOption(t *testing.T) {
+	type BuildArgs struct {
+		Cache bool `toggle:"" help:"use the build cache"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got BuildArgs
+	app.Add("build", func(a BuildArgs) {
+		got = a
+	})
+
+	if err := app.Run("build"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Cache {
+		t.Fatalf("expected Cache to default to true")
+	}
+
+	if err := app.Run("build", "--no-cache"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Cache {
+		t.Fatalf("expected --no-cache to disable Cache")
+	}
+
+	if err := app.Run("build", "--cache"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Cache {
+		t.Fatalf("expected --cache to enable Cache")
+	}
+
+	if err := app.Run("build", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--cache|--no-cache") {
+		t.Fatalf("expected both toggle forms in help, got %q", buf.String())
+	}
+}
+
+func TestInteractiveMissing(t *testing.T) {
+	type LoginArgs struct {
+		User string `arg:"0" help:"user name"`
+		Host string `arg:"1"`
+	}
+
+	orig := isTerminal
+	defer func() { isTerminal = orig }()
+
+	var buf bytes.Buffer
+	app := New(Options{
+		ExitOnError:        false,
+		Log:                &buf,
+		Input:              strings.NewReader("alice\nexample.com\n"),
+		InteractiveMissing: true,
+	})
+	var got LoginArgs
+	app.Add("login", func(a LoginArgs) {
+		got = a
+	})
+
+	// not a terminal: fall back to the missing argument error
+	isTerminal = func(r io.Reader) bool { return false }
+	if err := app.Run("login"); err == nil {
+		t.Fatalf("expected error without a terminal")
+	}
+
+	isTerminal = func(r io.Reader) bool { return true }
+	if err := app.Run("login"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.User != "alice" || got.Host != "example.com" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if buf.String() != "user name: host: " {
+		t.Fatalf("unexpected prompts: %q", buf.String())
+	}
+
+	// prompted values are checked like given values
+	strict := New(Options{
+		ExitOnError:        false,
+		Log:                io.Discard,
+		Input:              strings.NewReader("0\n"),
+		InteractiveMissing: true,
+	})
+	strict.Add("retry", func(a struct {
+		Count int `arg:"0" min:"1"`
+	}) {
+	})
+	if err := strict.Run("retry"); err == nil || !strings.Contains(err.Error(), "count must be at least 1") {
+		t.Fatalf("expected range error for a prompted value, got %v", err)
+	}
+}
+
+func TestAddStruct(t *testing.T) {
+	type CopyArgs struct {
+		Src   string `arg:"0"`
+		Force bool   `short:"f"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got CopyArgs
+	AddStruct(app, "copy", func(a CopyArgs) error {
+		got = a
+		return nil
+	})
+	AddStruct(app, "move", func(a *CopyArgs) error {
+		return fmt.Errorf("cannot move %s", a.Src)
+	})
+
+	if err := app.Run("copy", "a.txt", "-f"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Src != "a.txt" || !got.Force {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if err := app.Run("move", "b.txt"); err == nil || err.Error() != "cannot move b.txt" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "AddStruct requires a struct type, got int") {
+			t.Fatalf("expected panic for non-struct type, got %v", r)
+		}
+	}()
+	AddStruct(app, "count", func(n int) error { return nil })
+}
+
+func TestAddTokens(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	var got string
+	app.AddTokens([]string{"db", "migrate"}, func(target string) {
+		got = "migrate " + target
+	})
+	app.AddTokens([]string{"say", "hello world"}, func() {
+		got = "hello world"
+	})
+
+	if err := app.Run("db", "migrate", "v2"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "migrate v2" {
+		t.Fatalf("unexpected result %q", got)
+	}
+
+	if err := app.Run("say", "hello world"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "hello world" {
+		t.Fatalf("unexpected result %q", got)
+	}
+	if err := app.Run("say", "hello", "world"); err == nil {
+		t.Fatalf("expected tokens not to be re-split")
+	}
+
+	// a name that splits into the same words would hide the command
+	for _, register := range []func(){
+		func() { app.Add("say hello world", func() {}) },
+		func() { app.Alias("say hello world", "db migrate") },
+	} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `command "say hello world" conflicts`) {
+					t.Fatalf("expected panic for conflicting tokens, got %v", r)
+				}
+			}()
+			register()
+		}()
+	}
+	app.AddTokens([]string{"say", "hello world"}, func() { got = "replaced" })
+	if err := app.Run("say", "hello world"); err != nil || got != "replaced" {
+		t.Fatalf("expected the command to be replaced, got %q, %v", got, err)
+	}
+}
+
+func TestNoArgsBehavior(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("status", func() {})
+	if err := app.Run([]string{}...); err != nil {
+		t.Fatalf("expected help without error, got %v", err)
+	}
+	if !strings.Contains(buf.String(), "Commands:") {
+		t.Fatalf("expected global help, got %q", buf.String())
+	}
+
+	app = New(Options{ExitOnError: false, NoArgsBehavior: NoArgsError})
+	app.Add("status", func() {})
+	if err := app.Run([]string{}...); err == nil {
+		t.Fatalf("expected error for empty args")
+	}
+
+	app = New(Options{ExitOnError: false, NoArgsBehavior: NoArgsRunRoot})
+	ran := false
+	app.Add("", func() { ran = true })
+	if err := app.Run([]string{}...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !ran {
+		t.Fatalf("expected root handler to run")
+	}
+}
+
+func TestUsageSynopsis(t *testing.T) {
+	type CopyArgs struct {
+		Src   string   `arg:"0"`
+		Dst   string   `arg:"1" default:"."`
+		Force bool     `short:"-f"`
+		Out   string   `long:"--out"`
+		Rest  []string `rest:""`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("cp", func(a CopyArgs) {})
+	app.Add("add", func(a int, b int) {})
+
+	if err := app.Run("cp", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "  cp <src> [<dst>] [<rest>...] [--force] [--out <string>]\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("expected synopsis %q in %q", want, buf.String())
+	}
+
+	buf.Reset()
+	if err := app.Run("add", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "  add <arg0> <arg1>\n") {
+		t.Fatalf("unexpected usage %q", buf.String())
+	}
+}
+
+func TestHelpNeverExits(t *testing.T) {
+	type CreateArgs struct {
+		Input string `arg:"0"`
+	}
+
+	// ExitOnError is true: a non-nil error here would terminate the test binary
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: true, Log: &buf})
+	app.Add("create", func(a CreateArgs) {})
+	app.Add("add", func(a int, b int) {})
+
+	for _, args := range [][]string{
+		{"-h"}, {"--help"}, {"help"},
+		{"create", "-h"}, {"create", "--help"},
+		{"add", "-h"}, {"add", "--help"},
+	} {
+		buf.Reset()
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%v) returned %v", args, err)
+		}
+		if !strings.Contains(buf.String(), "Usage:") {
+			t.Fatalf("Run(%v) did not print help: %q", args, buf.String())
+		}
+	}
+}
+
+func TestNestedPointerAllocatedLazily(t *testing.T) {
+	type TLSOptions struct {
+		Cert string
+		Key  string
+	}
+	type ServeArgs struct {
+		Port int
+		TLS  *TLSOptions
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got ServeArgs
+	app.Add("serve", func(a ServeArgs) { got = a })
+
+	if err := app.Run("serve", "--port", "8080"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Port != 8080 || got.TLS != nil {
+		t.Fatalf("expected TLS to stay nil until one of its options is given: %+v", got)
+	}
+
+	if err := app.Run("serve", "--tls-key", "key.pem"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.TLS == nil || got.TLS.Key != "key.pem" || got.TLS.Cert != "" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// defaults and toggles apply once the group exists, but do not create it
+	type CacheOptions struct {
+		Dir     string `default:"/tmp/cache"`
+		Enabled bool   `toggle:""`
+	}
+	type BuildArgs struct {
+		Cache *CacheOptions
+	}
+	var build BuildArgs
+	app.Add("build", func(a BuildArgs) { build = a })
+	if err := app.Run("build"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if build.Cache != nil {
+		t.Fatalf("expected Cache to stay nil, got %+v", *build.Cache)
+	}
+	if err := app.Run("build", "--cache-dir", "/var/cache"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if build.Cache == nil || build.Cache.Dir != "/var/cache" || !build.Cache.Enabled {
+		t.Fatalf("unexpected args: %+v", build.Cache)
+	}
+	if err := app.Run("build", "--no-cache-enabled"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if build.Cache == nil || build.Cache.Dir != "/tmp/cache" || build.Cache.Enabled {
+		t.Fatalf("unexpected args: %+v", build.Cache)
+	}
+}
+
+func TestRunTraced(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	app.Add("sleep", func() {
+		time.Sleep(10 * time.Millisecond)
+	})
+
+	cmd, d, err := app.RunTraced("sleep")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if cmd != "sleep" || d < 10*time.Millisecond {
+		t.Fatalf("unexpected trace: command=%q duration=%v", cmd, d)
+	}
+
+	cmd, _, err = app.RunTraced("nope")
+	if err == nil || cmd != "(unknown)" {
+		t.Fatalf("unexpected trace: command=%q err=%v", cmd, err)
+	}
+
+	app.Add("", func() {})
+	cmd, _, err = app.RunTraced("x")
+	if err == nil || cmd != "(root)" {
+		t.Fatalf("unexpected trace: command=%q err=%v", cmd, err)
+	}
+}
+
+func TestShortOptionNormalization(t *testing.T) {
+	type LogArgs struct {
+		Verbose bool   `short:"v"`
+		Output  string `short:"-o"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) {
+		got = a
+	})
+
+	if err := app.Run("log", "-v", "-o", "out.txt"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Verbose || got.Output != "out.txt" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+}
+
+func TestMultiCharShortRejected(t *testing.T) {
+	type LogArgs struct {
+		Verbose bool `short:"-vv"`
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected panic for multi-character short option")
+			}
+		}()
+		app := New(Options{ExitOnError: false})
+		app.Add("log", func(a LogArgs) {})
+	}()
+
+	app := New(Options{ExitOnError: false, MultiCharShort: true})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) {
+		got = a
+	})
+	if err := app.Run("log", "-vv"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Verbose {
+		t.Fatalf("expected Verbose to be set")
+	}
+}
+
+func TestIndirectValues(t *testing.T) {
+	type DeployArgs struct {
+		Token string `long:"--token" indirect:""`
+		Note  string `long:"--note"`
+	}
+
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CLIAPP_TEST_TOKEN", "from-env")
+
+	app := New(Options{ExitOnError: false, Input: strings.NewReader("from-stdin\n")})
+	var got DeployArgs
+	app.Add("deploy", func(a DeployArgs) {
+		got = a
+	})
+
+	for _, tc := range []struct{ arg, want string }{
+		{"@file:" + path, "from-file"},
+		{"@env:CLIAPP_TEST_TOKEN", "from-env"},
+		{"@-", "from-stdin"},
+		{"plain", "plain"},
+	} {
+		if err := app.Run("deploy", "--token", tc.arg); err != nil {
+			t.Fatalf("Run(%q) failed: %v", tc.arg, err)
+		}
+		if got.Token != tc.want {
+			t.Fatalf("Run(%q): expected %q, got %q", tc.arg, tc.want, got.Token)
+		}
+	}
+
+	// options without the indirect tag keep @ values verbatim
+	if err := app.Run("deploy", "--note", "@env:CLIAPP_TEST_TOKEN"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Note != "@env:CLIAPP_TEST_TOKEN" {
+		t.Fatalf("expected verbatim value, got %q", got.Note)
+	}
+
+	for _, arg := range []string{"@env:CLIAPP_TEST_UNSET", "@file:" + path + ".missing", "@bogus"} {
+		if err := app.Run("deploy", "--token", arg); err == nil {
+			t.Fatalf("expected error for %q", arg)
+		}
+	}
+}
+
+func TestRequireExplicitTags(t *testing.T) {
+	type TaggedArgs struct {
+		Input   string `arg:"0"`
+		Verbose bool   `short:"-v"`
+	}
+	type UntaggedArgs struct {
+		Input    string `arg:"0"`
+		internal string
+	}
+
+	app := New(Options{ExitOnError: false, RequireExplicitTags: true})
+	app.Add("ok", func(a TaggedArgs) {})
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for untagged field")
+		}
+	}()
+	app.Add("bad", func(a UntaggedArgs) {})
+}
+
+func TestFlagCase(t *testing.T) {
+	type ServeArgs struct {
+		OutDir   string
+		HTTPPort int
+	}
+
+	for _, tc := range []struct {
+		flagCase  FlagCase
+		out, port string
+	}{
+		{FlagCaseKebab, "--out-dir", "--http-port"},
+		{FlagCaseSnake, "--out_dir", "--http_port"},
+		{FlagCaseCamel, "--outDir", "--httpPort"},
+		{FlagCaseLower, "--outdir", "--httpport"},
+	} {
+		app := New(Options{ExitOnError: false, FlagCase: tc.flagCase})
+		var got ServeArgs
+		app.Add("serve", func(a ServeArgs) {
+			got = a
+		})
+		if err := app.Run("serve", tc.out, "dist", tc.port, "8080"); err != nil {
+			t.Fatalf("case %d: Run failed: %v", tc.flagCase, err)
+		}
+		if got.OutDir != "dist" || got.HTTPPort != 8080 {
+			t.Fatalf("case %d: unexpected args: %+v", tc.flagCase, got)
+		}
+	}
+}
+
+func TestAddWithMeta(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	ran := false
+	app.AddWithMeta("db migrate", map[string]string{"stability": "beta"}, "Run migrations", func() {
+		ran = true
+	})
+	app.Add("status", func() {})
+
+	if err := app.Run("db", "migrate"); err != nil || !ran {
+		t.Fatalf("Run failed: ran=%v err=%v", ran, err)
+	}
+
+	meta, ok := app.Meta("db migrate")
+	if !ok || meta["stability"] != "beta" {
+		t.Fatalf("unexpected meta: %v %v", meta, ok)
+	}
+	meta["stability"] = "stable"
+	if meta, _ := app.Meta("db migrate"); meta["stability"] != "beta" {
+		t.Fatalf("metadata should not be mutable through Meta")
+	}
+
+	if meta, ok := app.Meta("status"); !ok || meta != nil {
+		t.Fatalf("expected no metadata for status, got %v %v", meta, ok)
+	}
+	if _, ok := app.Meta("missing"); ok {
+		t.Fatalf("expected missing command to report false")
+	}
+}
+
+func TestDurationParameters(t *testing.T) {
+	type WaitArgs struct {
+		Timeout  time.Duration  `long:"--timeout"`
+		Interval *time.Duration `long:"--interval"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got time.Duration
+	app.Add("wait", func(d time.Duration) {
+		got = d
+	})
+	var args WaitArgs
+	app.Add("poll", func(a WaitArgs) {
+		args = a
+	})
+
+	if err := app.Run("wait", "1m30s"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != 90*time.Second {
+		t.Fatalf("expected 1m30s, got %v", got)
+	}
+
+	if err := app.Run("poll", "--timeout", "30s", "--interval=500ms"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if args.Timeout != 30*time.Second || args.Interval == nil || *args.Interval != 500*time.Millisecond {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	if err := app.Run("wait", "soon"); err == nil {
+		t.Fatalf("expected error for invalid duration")
+	}
+
+	if err := app.Run("wait", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "arg0 <duration>") {
+		t.Fatalf("expected <duration> label in help, got %q", buf.String())
+	}
+}
+
+func TestSizedIntegers(t *testing.T) {
+	type NetArgs struct {
+		Port uint16 `long:"--port"`
+		TTL  *int8  `long:"--ttl"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var port uint16
+	var delta int32
+	app.Add("listen", func(p uint16, d int32) {
+		port, delta = p, d
+	})
+	var args NetArgs
+	app.Add("dial", func(a NetArgs) {
+		args = a
+	})
+
+	if err := app.Run("listen", "8080", "-5"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if port != 8080 || delta != -5 {
+		t.Fatalf("unexpected values: %d %d", port, delta)
+	}
+
+	if err := app.Run("dial", "--port", "443", "--ttl", "64"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if args.Port != 443 || args.TTL == nil || *args.TTL != 64 {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	err := app.Run("listen", "70000", "0")
+	if err == nil || !strings.Contains(err.Error(), "arg 1") || !strings.Contains(err.Error(), "70000 is out of range for uint16 (0 to 65535)") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = app.Run("dial", "--ttl", "200")
+	if err == nil || !strings.Contains(err.Error(), "--ttl") || !strings.Contains(err.Error(), "(-128 to 127)") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app.Add("scale", func(f float64) {})
+	err = app.Run("scale", "1e400")
+	if err == nil || !strings.Contains(err.Error(), "1e400 is out of range for float64") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := app.Run("listen", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "arg0 <uint16>") || !strings.Contains(buf.String(), "arg1 <int32>") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+}
+
+func TestFloat32(t *testing.T) {
+	type ScaleArgs struct {
+		Factor float32  `long:"--factor"`
+		Offset *float32 `long:"--offset"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var x float32
+	app.Add("set", func(v float32) {
+		x = v
+	})
+	var args ScaleArgs
+	app.Add("scale", func(a ScaleArgs) {
+		args = a
+	})
+
+	if err := app.Run("set", "1.5"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if x != 1.5 {
+		t.Fatalf("unexpected value: %v", x)
+	}
+	if err := app.Run("scale", "--factor", "0.25", "--offset", "-2"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if args.Factor != 0.25 || args.Offset == nil || *args.Offset != -2 {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	err := app.Run("set", "1e39")
+	if err == nil || !strings.Contains(err.Error(), "1e39 is out of range for float32") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = app.Run("scale", "--factor", "-1e40")
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Option != "--factor" || !strings.Contains(err.Error(), "out of range for float32") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := app.Run("set", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "arg0 <float32>") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+}
+
+func TestOptionalPointerArgs(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var name string
+	var enabled *bool
+	var level *int
+	app.Add("feature", func(n string, e *bool, l *int) {
+		name, enabled, level = n, e, l
+	})
+
+	if err := app.Run("feature", "cache", "false", "3"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if name != "cache" || enabled == nil || *enabled || level == nil || *level != 3 {
+		t.Fatalf("unexpected values: %s %v %v", name, enabled, level)
+	}
+
+	if err := app.Run("feature", "cache", "true"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if enabled == nil || !*enabled || level != nil {
+		t.Fatalf("unexpected values: %v %v", enabled, level)
+	}
+
+	if err := app.Run("feature", "cache"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if enabled != nil || level != nil {
+		t.Fatalf("expected omitted pointers to be nil: %v %v", enabled, level)
+	}
+
+	err := app.Run("feature")
+	if err == nil || !strings.Contains(err.Error(), "want 1 to 3, got 0") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := app.Run("feature", "cache", "maybe"); !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("expected invalid value error, got %v", err)
+	}
+
+	if err := app.Run("feature", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "feature <arg0> [<arg1>] [<arg2>]") || !strings.Contains(buf.String(), "arg1 <bool>") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+}
+
+func TestRepeatedSliceOptions(t *testing.T) {
+	type TagArgs struct {
+		Tags  []string  `long:"--tag" short:"-t" help:"tag to add"`
+		IDs   []int     `long:"--id"`
+		Big   []int64   `long:"--big"`
+		Ratio []float64 `long:"--ratio"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got TagArgs
+	app.Add("tag", func(a TagArgs) {
+		got = a
+	})
+
+	if err := app.Run("tag", "--tag", "foo", "-t", "bar", "--id=1", "--id", "2", "--big", "3", "--ratio", "0.5"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Tags, []string{"foo", "bar"}) || !reflect.DeepEqual(got.IDs, []int{1, 2}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if !reflect.DeepEqual(got.Big, []int64{3}) || !reflect.DeepEqual(got.Ratio, []float64{0.5}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("tag", "--id", "x"); err == nil {
+		t.Fatalf("expected error for invalid element")
+	}
+
+	if err := app.Run("tag", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "-t|--tag <string>...    tag to add (repeatable)") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+}
+
+func TestOptionDefaults(t *testing.T) {
+	type BuildArgs struct {
+		Output string  `long:"--out" default:"out.txt" help:"output file"`
+		Jobs   *int    `long:"--jobs" default:"4"`
+		Tag    *string `long:"--tag"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got BuildArgs
+	app.Add("build", func(a BuildArgs) {
+		got = a
+	})
+
+	if err := app.Run("build"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Output != "out.txt" || got.Jobs == nil || *got.Jobs != 4 || got.Tag != nil {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("build", "--out", "a.txt", "--jobs", "8"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Output != "a.txt" || *got.Jobs != 8 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("build", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--out <string>          output file (default: out.txt)") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+
+	type BadArgs struct {
+		Jobs int `default:"many"`
+	}
+	app.Add("bad", func(a BadArgs) {})
+	err := app.Run("bad")
+	if err == nil || !strings.Contains(err.Error(), `invalid default "many" for option --jobs`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestRequiredOptions(t *testing.T) {
+	type DeployArgs struct {
+		Target string `arg:"0" required:"true"`
+		Token  string `long:"--token" required:"true" help:"API token"`
+		User   string `long:"--user" required:"true"`
+		Region string `long:"--region" required:"true" default:"us"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got DeployArgs
+	app.Add("deploy", func(a DeployArgs) {
+		got = a
+	})
+
+	if err := app.Run("deploy", "prod", "--token", "t", "--user", "u"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Target != "prod" || got.Token != "t" || got.User != "u" || got.Region != "us" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("deploy", "prod", "--user", "u")
+	if err == nil || !strings.Contains(err.Error(), "missing required option: --token") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err = app.Run("deploy", "prod")
+	if err == nil || !strings.Contains(err.Error(), "missing required options: --token, --user") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := app.Run("deploy"); err == nil {
+		t.Fatalf("expected error for missing positional")
+	}
+
+	if err := app.Run("deploy", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "--token <string>        API token (required)") {
+		t.Fatalf("unexpected help: %q", out)
+	}
+	if !strings.Contains(out, "deploy <target> --token <string> --user <string> [--region <string>]") {
+		t.Fatalf("unexpected synopsis: %q", out)
+	}
+}
+
+func TestEnvFallback(t *testing.T) {
+	type APIArgs struct {
+		Token    string `long:"--token" env:"API_TOKEN" default:"none" help:"API token"`
+		Endpoint string `long:"--endpoint" env:"API_ENDPOINT" required:"true"`
+		Retries  int    `long:"--retries" env:"API_RETRIES"`
+	}
+
+	env := map[string]string{"API_TOKEN": "from-env", "API_ENDPOINT": "https://example.com"}
+	var buf bytes.Buffer
+	app := New(Options{
+		ExitOnError: false,
+		Log:         &buf,
+		LookupEnv: func(key string) (string, bool) {
+			v, ok := env[key]
+			return v, ok
+		},
+	})
+	var got APIArgs
+	app.Add("call", func(a APIArgs) {
+		got = a
+	})
+
+	// env wins over default, and satisfies required
+	if err := app.Run("call"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Token != "from-env" || got.Endpoint != "https://example.com" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// command line wins over env
+	if err := app.Run("call", "--token", "from-cli"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Token != "from-cli" {
+		t.Fatalf("expected command line value, got %q", got.Token)
+	}
+
+	// default applies when neither is set
+	delete(env, "API_TOKEN")
+	if err := app.Run("call"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Token != "none" {
+		t.Fatalf("expected default, got %q", got.Token)
+	}
+
+	env["API_RETRIES"] = "lots"
+	if err := app.Run("call"); err == nil {
+		t.Fatalf("expected error for invalid env value")
+	}
+
+	if err := app.Run("call", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "API token (env: API_TOKEN) (default: none)") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+}
+
+func TestCombinedShortFlags(t *testing.T) {
+	type ArchiveArgs struct {
+		Create  bool   `short:"-c"`
+		Verbose bool   `short:"-v"`
+		Gzip    bool   `short:"-z"`
+		File    string `short:"-f"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got ArchiveArgs
+	app.Add("tar", func(a ArchiveArgs) {
+		got = a
+	})
+
+	for _, args := range [][]string{
+		{"tar", "-cvz", "-f", "out.tgz"},
+		{"tar", "-cvzf", "out.tgz"},
+		{"tar", "-cvzfout.tgz"},
+	} {
+		got = ArchiveArgs{}
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%v) failed: %v", args, err)
+		}
+		if !got.Create || !got.Verbose || !got.Gzip || got.File != "out.tgz" {
+			t.Fatalf("Run(%v): unexpected args: %+v", args, got)
+		}
+	}
+
+	err := app.Run("tar", "-cxv")
+	if err == nil || !strings.Contains(err.Error(), "unknown option: -x in -cxv") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := app.Run("tar", "-cf"); err == nil {
+		t.Fatalf("expected missing value error")
+	}
+}
+
+func TestAttachedShortValues(t *testing.T) {
+	type RunArgs struct {
+		Output  string `short:"-o"`
+		Verbose bool   `short:"-v"`
+		Procs   int    `short:"-np"`
+	}
+
+	app := New(Options{ExitOnError: false, MultiCharShort: true})
+	var got RunArgs
+	app.Add("run", func(a RunArgs) {
+		got = a
+	})
+
+	if err := app.Run("run", "-ofoo.txt", "-np4"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Output != "foo.txt" || got.Procs != 4 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("run", "-o", "bar.txt", "-np", "2"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Output != "bar.txt" || got.Procs != 2 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// a bool flag never takes an attached value
+	if err := app.Run("run", "-vtrue"); err == nil {
+		t.Fatalf("expected error for value attached to a bool flag")
+	}
+}
+
+func TestOptionTerminator(t *testing.T) {
+	type RunArgs struct {
+		Script  string   `arg:"0"`
+		Verbose bool     `short:"-v"`
+		Rest    []string `rest:""`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got RunArgs
+	app.Add("run", func(a RunArgs) {
+		got = a
+	})
+	var echoed string
+	app.Add("echo", func(s string) {
+		echoed = s
+	})
+
+	if err := app.Run("run", "main.sh", "-v", "--", "--not-an-option", "-x"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Script != "main.sh" || !got.Verbose || !reflect.DeepEqual(got.Rest, []string{"--not-an-option", "-x"}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// positionals not yet bound are captured after the terminator
+	if err := app.Run("run", "--", "--script.sh", "extra"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Script != "--script.sh" || !reflect.DeepEqual(got.Rest, []string{"extra"}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("echo", "--", "--flag"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if echoed != "--flag" {
+		t.Fatalf("expected --flag, got %q", echoed)
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	type SumArgs struct {
+		Scale  float64  `long:"--scale"`
+		Values []string `rest:""`
+	}
+	type MoveArgs struct {
+		X float64 `arg:"0"`
+		Y int     `arg:"1"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var n int
+	app.Add("neg", func(x int) {
+		n = x
+	})
+	var sum SumArgs
+	app.Add("sum", func(a SumArgs) {
+		sum = a
+	})
+	var move MoveArgs
+	app.Add("move", func(a MoveArgs) {
+		move = a
+	})
+
+	if err := app.Run("neg", "-5"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if n != -5 {
+		t.Fatalf("expected -5, got %d", n)
+	}
+
+	if err := app.Run("sum", "--scale", "-0.5", "-5", "-3.14"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if sum.Scale != -0.5 || !reflect.DeepEqual(sum.Values, []string{"-5", "-3.14"}) {
+		t.Fatalf("unexpected args: %+v", sum)
+	}
+
+	if err := app.Run("move", "-3.14", "-2"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if move.X != -3.14 || move.Y != -2 {
+		t.Fatalf("unexpected args: %+v", move)
+	}
+
+	if err := app.Run("sum", "-x"); err == nil {
+		t.Fatalf("expected unknown option error for -x")
+	}
+}
+
+func TestAlias(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+
+	calls := 0
+	app.Add("list", "List items", func() { calls++ })
+	app.Alias("ls", "list")
+
+	cmd, _, err := app.RunTraced("ls")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if calls != 1 || cmd != "list" {
+		t.Fatalf("alias did not resolve: calls=%d command=%q", calls, cmd)
+	}
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "  list (alias: ls)  List items\n") || strings.Count(out, "List items") != 1 {
+		t.Fatalf("unexpected help:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := app.Run("ls", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "List items") || !strings.Contains(buf.String(), "  list") {
+		t.Fatalf("unexpected command help:\n%s", buf.String())
+	}
+}
+
+type color int
+
+const (
+	red color = iota
+	green
+	blue
+)
+
+var colorNames = []string{"red", "green", "blue"}
+
+func (c *color) Set(s string) error {
+	for i, name := range colorNames {
+		if s == name {
+			*c = color(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid color %q", s)
+}
+
+func (c *color) String() string { return colorNames[*c] }
+
+func TestFlagValue(t *testing.T) {
+	type PaintArgs struct {
+		Fill   color  `arg:"0"`
+		Border *color `help:"border color"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+
+	var got color
+	app.Add("fill", func(c color) { got = c })
+	if err := app.Run("fill", "blue"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != blue {
+		t.Fatalf("expected blue, got %v", got)
+	}
+	if err := app.Run("fill", "pink"); err == nil || !strings.Contains(err.Error(), `invalid color "pink"`) {
+		t.Fatalf("expected invalid color error, got %v", err)
+	}
+
+	var args PaintArgs
+	app.Add("paint", func(a PaintArgs) { args = a })
+	if err := app.Run("paint", "green", "--border", "red"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if args.Fill != green || args.Border == nil || *args.Border != red {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	if err := app.Run("paint", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--border <color>") {
+		t.Fatalf("expected type label from type name:\n%s", buf.String())
+	}
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	type ServeArgs struct {
+		Addr  net.IP     `arg:"0"`
+		Since *time.Time `help:"start time"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+
+	var ip net.IP
+	app.Add("ping", func(addr net.IP) { ip = addr })
+	if err := app.Run("ping", "10.0.0.1"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !ip.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("unexpected ip: %v", ip)
+	}
+
+	var args ServeArgs
+	app.Add("serve", func(a ServeArgs) { args = a })
+	if err := app.Run("serve", "::1", "--since", "2024-01-02T03:04:05Z"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !args.Addr.Equal(net.IPv6loopback) || args.Since == nil || args.Since.Year() != 2024 {
+		t.Fatalf("unexpected args: %+v", args)
+	}
+
+	err := app.Run("serve", "::1", "--since", "yesterday")
+	if err == nil || !strings.Contains(err.Error(), "option --since") {
+		t.Fatalf("expected wrapped parse error, got %v", err)
+	}
+
+	if err := app.Run("serve", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--since <time>") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+}
+
+func TestNegatableFlags(t *testing.T) {
+	type LogArgs struct {
+		Verbose bool  `long:"--verbose" default:"true"`
+		Color   *bool `help:"colorize output"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) {
+		got = a
+	})
+
+	if err := app.Run("log", "--no-verbose", "--no-color"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Verbose || got.Color == nil || *got.Color {
+		t.Fatalf("expected both flags to be false, got %+v", got)
+	}
+
+	if err := app.Run("log", "--no-verbose=true"); err == nil || !strings.Contains(err.Error(), "does not take a value") {
+		t.Fatalf("expected malformed option error, got %v", err)
+	}
+
+	if err := app.Run("log", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "--color|--no-color        colorize output") || !strings.Contains(out, "log [--verbose] [--color]") {
+		t.Fatalf("unexpected help:\n%s", out)
+	}
+}
+
+func TestBoolOptionValue(t *testing.T) {
+	type LogArgs struct {
+		Verbose bool  `toggle:""`
+		Color   *bool `long:"--color"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) {
+		got = a
+	})
+
+	if err := app.Run("log", "--verbose=false", "--color=true"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Verbose || got.Color == nil || !*got.Color {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("log", "--verbose=1", "--color=0"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Verbose || got.Color == nil || *got.Color {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("log", "--verbose=maybe")
+	if err == nil || !strings.Contains(err.Error(), `invalid boolean value "maybe" for option --verbose`) {
+		t.Fatalf("expected invalid boolean error, got %v", err)
+	}
+}
+
+func TestCountFlag(t *testing.T) {
+	type LogArgs struct {
+		Verbose int  `short:"v" count:"true" help:"increase verbosity"`
+		Quiet   bool `short:"q"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) {
+		got = a
+	})
+
+	cases := []struct {
+		args []string
+		want int
+	}{
+		{[]string{"log"}, 0},
+		{[]string{"log", "-v", "-v", "-v"}, 3},
+		{[]string{"log", "-vvv"}, 3},
+		{[]string{"log", "-vqv", "--verbose"}, 3},
+		{[]string{"log", "--verbose=5"}, 5},
+	}
+	for _, c := range cases {
+		if err := app.Run(c.args...); err != nil {
+			t.Fatalf("Run(%v) failed: %v", c.args, err)
+		}
+		if got.Verbose != c.want {
+			t.Fatalf("Run(%v): expected %d, got %d", c.args, c.want, got.Verbose)
+		}
+	}
+
+	if err := app.Run("log", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "-v|--verbose             increase verbosity (repeatable, counts occurrences)") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for a non-integer count field")
+		}
+	}()
+	type BadArgs struct {
+		Level string `count:"true"`
+	}
+	app.Add("bad", func(a BadArgs) {})
+}
+
+func TestRunContext(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+
+	started := make(chan struct{})
+	app.Add("wait", func(ctx context.Context, name string) error {
+		if name != "job" {
+			return fmt.Errorf("unexpected name %q", name)
+		}
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	if err := app.RunContext(ctx, "wait", "job"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	// the context parameter is not counted as an argument
+	if err := app.Run("wait"); err == nil || !strings.Contains(err.Error(), "want 1, got 0") {
+		t.Fatalf("expected wrong argument count error, got %v", err)
+	}
+
+	var got context.Context
+	app.Add("bg", func(ctx context.Context) { got = ctx })
+	if err := app.Run("bg"); err != nil || got == nil {
+		t.Fatalf("expected Run to pass a background context: ctx=%v err=%v", got, err)
+	}
+}
+
+func TestVariadicHandler(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+
+	var gotMode string
+	var gotPaths []string
+	app.Add("chmod", func(mode string, paths ...string) {
+		gotMode, gotPaths = mode, paths
+	})
+	var sum int
+	app.Add("sum", func(nums ...int) {
+		sum = 0
+		for _, n := range nums {
+			sum += n
+		}
+	})
+
+	if err := app.Run("chmod", "755", "a", "b", "c"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if gotMode != "755" || !reflect.DeepEqual(gotPaths, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected args: mode=%q paths=%v", gotMode, gotPaths)
+	}
+	if err := app.Run("chmod", "644"); err != nil || len(gotPaths) != 0 {
+		t.Fatalf("expected no paths: paths=%v err=%v", gotPaths, err)
+	}
+	if err := app.Run("chmod"); err == nil || !strings.Contains(err.Error(), "want at least 1, got 0") {
+		t.Fatalf("expected minimum argument error, got %v", err)
+	}
+
+	if err := app.Run("sum", "1", "2", "3"); err != nil || sum != 6 {
+		t.Fatalf("unexpected sum: %d err=%v", sum, err)
+	}
+	if err := app.Run("sum", "1", "x"); err == nil {
+		t.Fatalf("expected parse error for a variadic element")
+	}
+
+	if err := app.Run("chmod", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	if !strings.Contains(out, "chmod <arg0> [<arg1>...]") || !strings.Contains(out, "[1] arg1 <string...>") {
+		t.Fatalf("unexpected help:\n%s", out)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("", func() {})
+	app.Add("list", "List items", func() {})
+	app.Alias("ls", "list")
+	app.Add("status", "Show status", func() {})
+
+	if !app.Remove("list") {
+		t.Fatalf("expected list to be removed")
+	}
+	if app.Remove("list") {
+		t.Fatalf("expected a second Remove to report nothing removed")
+	}
+	for _, name := range []string{"list", "ls"} {
+		err := app.Run(name, "x")
+		if err == nil {
+			t.Fatalf("expected %s to be gone", name)
+		}
+	}
+
+	if !app.Remove("") || app.Remove("") {
+		t.Fatalf("expected the root command to be removed once")
+	}
+	if err := app.Run("list"); err == nil || err.Error() != "unknown command: list" {
+		t.Fatalf("expected unknown command error, got %v", err)
+	}
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if strings.Contains(buf.String(), "list") || !strings.Contains(buf.String(), "status") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+}
+
+func TestCommands(t *testing.T) {
+	type BuildArgs struct {
+		Out string
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("status", "Show status", func() {})
+	app.AddWithMeta("build", map[string]string{"category": "dev"}, "Build it", func(a BuildArgs) {})
+	app.Add("remote add", func(name string, urls ...string) {})
+	app.Alias("b", "build")
+
+	cmds := app.Commands()
+	var names []string
+	for _, c := range cmds {
+		names = append(names, c.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"build", "remote add", "status"}) {
+		t.Fatalf("unexpected command names: %v", names)
+	}
+
+	build := cmds[0]
+	if build.Help != "Build it" || !build.Struct || build.Meta["category"] != "dev" || !reflect.DeepEqual(build.Aliases, []string{"b"}) {
+		t.Fatalf("unexpected build info: %+v", build)
+	}
+	remote := cmds[1]
+	if remote.Struct || !remote.Variadic || len(remote.Params) != 2 || remote.Params[0].Kind() != reflect.String {
+		t.Fatalf("unexpected remote add info: %+v", remote)
+	}
+
+	// the snapshot is not shared with the app
+	build.Meta["category"] = "changed"
+	if m, _ := app.Meta("build"); m["category"] != "dev" {
+		t.Fatalf("Commands exposed internal metadata")
+	}
+}
+
+func TestHelpFlags(t *testing.T) {
+	type ConnectArgs struct {
+		Host string `short:"h" long:"--host"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, HelpFlags: []string{"-?", "--usage"}})
+	var got ConnectArgs
+	app.Add("connect", func(a ConnectArgs) { got = a })
+
+	if err := app.Run("connect", "-h", "example.com"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Host != "example.com" || buf.Len() != 0 {
+		t.Fatalf("expected -h to set the host: %+v %q", got, buf.String())
+	}
+
+	for _, args := range [][]string{{"connect", "-?"}, {"connect", "--usage"}, {"--usage"}, {"help"}} {
+		buf.Reset()
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+		if !strings.Contains(buf.String(), "-?|--usage") {
+			t.Fatalf("Run(%q): expected help, got %q", args, buf.String())
+		}
+	}
+	if err := app.Run("connect", "--help"); !errors.Is(err, ErrUnknownOption) {
+		t.Fatalf("expected --help to be unknown, got %v", err)
+	}
+
+	// an empty list leaves only the help command
+	buf.Reset()
+	disabled := New(Options{ExitOnError: false, Log: &buf, HelpFlags: []string{}})
+	disabled.Add("connect", func(a ConnectArgs) { got = a })
+	if err := disabled.Run("connect", "--help"); !errors.Is(err, ErrUnknownOption) {
+		t.Fatalf("expected --help to be unknown, got %v", err)
+	}
+	if err := disabled.Run("help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "connect") || strings.Contains(buf.String(), "Show this help") {
+		t.Fatalf("unexpected help: %q", buf.String())
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "option --usage of field Usage collides with the built-in help option") {
+			t.Fatalf("expected panic for option named like a help flag, got %v", r)
+		}
+	}()
+	app.Add("bad", func(a struct{ Usage bool }) {})
+}
+
+func TestHelpCommand(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var topic string
+	app.Add("help", func(s string) { topic = s })
+	app.Add("build", "Build it", func() {})
+
+	if err := app.Run("help", "build"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if topic != "build" || buf.Len() != 0 {
+		t.Fatalf("expected the registered help command to run: %q %q", topic, buf.String())
+	}
+
+	renamed := New(Options{ExitOnError: false, Log: &buf, HelpCommand: "usage"})
+	renamed.Add("build", "Build it", func() {})
+	if err := renamed.Run("usage"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Build it") {
+		t.Fatalf("expected help, got %q", buf.String())
+	}
+	if err := renamed.Run("help"); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("expected help to be unknown, got %v", err)
+	}
+
+	disabled := New(Options{ExitOnError: false, Log: &buf, HelpCommand: "-"})
+	disabled.Add("build", func() {})
+	if err := disabled.Run("help"); !errors.Is(err, ErrUnknownCommand) {
+		t.Fatalf("expected help to be unknown, got %v", err)
+	}
+}
+
+func TestHelpCommandTopic(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("build", "Build it", func(target string) {})
+	app.Add("remote add", "Add a remote", func(name string) {})
+	app.Alias("ra", "remote add")
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"help", "build"}, "build <arg0>"},
+		{[]string{"help", "remote", "add"}, "remote add <arg0>"},
+		{[]string{"help", "ra"}, "Add a remote"},
+		{[]string{"help", "remote"}, "add"},
+	} {
+		buf.Reset()
+		if err := app.Run(tc.args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", tc.args, err)
+		}
+		if !strings.Contains(buf.String(), tc.want) {
+			t.Fatalf("Run(%q): expected %q in help, got:\n%s", tc.args, tc.want, buf.String())
+		}
+	}
+
+	for _, args := range [][]string{{"help", "buidl"}, {"help", "build", "extra"}} {
+		err := app.Run(args...)
+		var uc *UnknownCommandError
+		if !errors.As(err, &uc) {
+			t.Fatalf("Run(%q): expected unknown command error, got %v", args, err)
+		}
+		if args[1] == "buidl" && uc.Suggestion != "build" {
+			t.Fatalf("expected suggestion build, got %q", uc.Suggestion)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	app.Add("", "Run the tool", func() {})
+	app.Add("remote add", "Add a remote", func(name string) {})
+	app.Alias("ra", "remote add")
+
+	for _, tc := range []struct {
+		name string
+		help string
+		ok   bool
+	}{
+		{"remote add", "Add a remote", true},
+		{"  remote   add ", "Add a remote", true},
+		{"ra", "Add a remote", true},
+		{"", "Run the tool", true},
+		{"remote", "", false},
+		{"missing", "", false},
+	} {
+		help, ok := app.Lookup(tc.name)
+		if help != tc.help || ok != tc.ok {
+			t.Fatalf("Lookup(%q) = %q, %v; want %q, %v", tc.name, help, ok, tc.help, tc.ok)
+		}
+	}
+
+	info, ok := app.Command("ra")
+	if !ok || info.Name != "remote add" || !reflect.DeepEqual(info.Aliases, []string{"ra"}) {
+		t.Fatalf("unexpected command info: %+v %v", info, ok)
+	}
+	if _, ok := app.Command("missing"); ok {
+		t.Fatalf("expected missing command not to be found")
+	}
+}
+
+func TestHelpOrderIsStable(t *testing.T) {
+	type RootArgs struct {
+		Zeta  bool
+		Alpha string
+		Mid   int
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("", func(a RootArgs) {})
+	for _, name := range []string{"delta", "alpha", "charlie", "bravo", "echo", "alpha two"} {
+		app.Add(name, func() {})
+	}
+
+	render := func(args ...string) string {
+		var buf bytes.Buffer
+		app.opts.Log = &buf
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		return buf.String()
+	}
+
+	want := render("-h")
+	for range 20 {
+		if got := render("-h"); got != want {
+			t.Fatalf("help output changed between runs:\n%s\n---\n%s", want, got)
+		}
+	}
+
+	var order []string
+	for _, line := range strings.Split(want, "\n") {
+		if strings.HasSuffix(line, "(args: 0)") || strings.HasPrefix(strings.TrimSpace(line), "--") {
+			order = append(order, strings.Fields(line)[0])
+		}
+	}
+	wantOrder := []string{"alpha", "alpha", "bravo", "charlie", "delta", "echo", "--zeta|--no-zeta", "--alpha", "--mid"}
+	if !reflect.DeepEqual(order, wantOrder) {
+		t.Fatalf("unexpected order %v in:\n%s", order, want)
+	}
+}
+
+func TestUnknownCommandSuggestion(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	app.Add("status", func() {})
+	app.Add("stash", func() {})
+	app.Add("remote add", func(name string) {})
+
+	cases := map[string][]string{
+		"unknown command: stauts. Did you mean 'status'?":     {"stauts"},
+		"unknown command: remote. Did you mean 'remote add'?": {"remote", "ad", "origin"},
+		"unknown command: deploy":                             {"deploy"},
+		"unknown command: x":                                  {"x"},
+	}
+	for want, args := range cases {
+		err := app.Run(args...)
+		if err == nil || err.Error() != want {
+			t.Fatalf("Run(%v): expected %q, got %v", args, want, err)
+		}
+	}
+
+	if got := levenshtein("kitten", "sitting"); got != 3 {
+		t.Fatalf("levenshtein: expected 3, got %d", got)
+	}
+}
+
+func TestAbbreviatedLongOptions(t *testing.T) {
+	type ServeArgs struct {
+		Verbose bool
+		Version bool
+		Port    int
+	}
+
+	var got ServeArgs
+	strict := New(Options{ExitOnError: false})
+	strict.Add("serve", func(a ServeArgs) { got = a })
+	if err := strict.Run("serve", "--po", "80"); err == nil {
+		t.Fatalf("expected abbreviations to be rejected without AllowAbbrev")
+	}
+
+	app := New(Options{ExitOnError: false, AllowAbbrev: true})
+	app.Add("serve", func(a ServeArgs) { got = a })
+
+	if err := app.Run("serve", "--verb", "--po", "80"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.Verbose || got.Port != 80 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if err := app.Run("serve", "--p=8080", "--no-verb"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Verbose || got.Port != 8080 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("serve", "--ver")
+	if err == nil || !strings.Contains(err.Error(), "ambiguous option: --ver could be --verbose, --version") {
+		t.Fatalf("expected ambiguous option error, got %v", err)
+	}
+}
+
+func TestChoices(t *testing.T) {
+	type LogArgs struct {
+		Level string   `long:"--level" choices:"debug,info,warn,error" help:"log level"`
+		Tags  []string `choices:"a, b"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got LogArgs
+	app.Add("log", func(a LogArgs) { got = a })
+
+	if err := app.Run("log", "--level", "warn", "--tags", "a", "--tags", "b"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Level != "warn" || !reflect.DeepEqual(got.Tags, []string{"a", "b"}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("log", "--level", "WARN")
+	if err == nil || !strings.Contains(err.Error(), `invalid value "WARN" (choices: debug, info, warn, error)`) {
+		t.Fatalf("expected choices error, got %v", err)
+	}
+	if err := app.Run("log", "--tags", "c"); err == nil {
+		t.Fatalf("expected choices error for a slice element")
+	}
+
+	app.opts.ChoicesIgnoreCase = true
+	if err := app.Run("log", "--level=WARN"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Level != "warn" {
+		t.Fatalf("expected the canonical choice, got %q", got.Level)
+	}
+
+	if err := app.Run("log", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "log level (choices: debug, info, warn, error)") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	env := New(Options{ExitOnError: false, LookupEnv: func(key string) (string, bool) {
+		return "bogus", key == "LEVEL"
+	}})
+	env.Add("log", func(a struct {
+		Level string `choices:"debug,info" env:"LEVEL"`
+	}) {
+	})
+	if err := env.Run("log"); err == nil || !strings.Contains(err.Error(), `invalid value "bogus" (choices: debug, info)`) {
+		t.Fatalf("expected choices error for an environment value, got %v", err)
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `default: invalid value "zzz" (choices: x, y)`) {
+			t.Fatalf("expected panic for a default outside the choices, got %v", r)
+		}
+	}()
+	app.Add("bad", func(a struct {
+		Mode string `default:"zzz" choices:"x,y"`
+	}) {
+	})
+}
+
+func TestRangeTags(t *testing.T) {
+	type ServeArgs struct {
+		Port  int      `long:"--port" min:"1" max:"65535" help:"listen port"`
+		Ratio *float64 `max:"1"`
+		Retry int64    `arg:"0" min:"0"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got ServeArgs
+	app.Add("serve", func(a ServeArgs) { got = a })
+
+	if err := app.Run("serve", "3", "--port", "8080", "--ratio", "0.5"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Port != 8080 || got.Ratio == nil || *got.Ratio != 0.5 || got.Retry != 3 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	cases := map[string][]string{
+		"--port must be between 1 and 65535": {"serve", "0", "--port", "70000"},
+		"--ratio must be at most 1":          {"serve", "0", "--ratio=1.5"},
+		"retry must be at least 0":           {"serve", "-1"},
+	}
+	for want, args := range cases {
+		if err := app.Run(args...); err == nil || !strings.Contains(err.Error(), want) {
+			t.Fatalf("Run(%v): expected %q, got %v", args, want, err)
+		}
+	}
+
+	if err := app.Run("serve", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "listen port (between 1 and 65535)") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	env := New(Options{ExitOnError: false, LookupEnv: func(key string) (string, bool) {
+		return "9999", key == "PORT"
+	}})
+	env.Add("serve", func(a struct {
+		Port int `env:"PORT" max:"10"`
+	}) {
+	})
+	if err := env.Run("serve"); err == nil || !strings.Contains(err.Error(), "--port must be at most 10") {
+		t.Fatalf("expected range error for an environment value, got %v", err)
+	}
+
+	for _, register := range []func(){
+		func() {
+			type BadArgs struct {
+				Name string `min:"1"`
+			}
+			app.Add("bad", func(a BadArgs) {})
+		},
+		func() {
+			type BadArgs struct {
+				Port int `default:"0" min:"1"`
+			}
+			app.Add("bad", func(a BadArgs) {})
+		},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Fatalf("expected panic for an invalid range tag or default")
+				}
+			}()
+			register()
+		}()
+	}
+}
+
+func TestHidden(t *testing.T) {
+	type BuildArgs struct {
+		Out   string
+		Trace bool `hidden:"true"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got BuildArgs
+	app.Add("build", "Build it", func(a BuildArgs) { got = a })
+	ran := false
+	app.AddHidden("debug-dump", "Dump internals", func() { ran = true })
+
+	if err := app.Run("debug-dump"); err != nil || !ran {
+		t.Fatalf("hidden command did not run: ran=%v err=%v", ran, err)
+	}
+	if err := app.Run("build", "--trace"); err != nil || !got.Trace {
+		t.Fatalf("hidden option was not parsed: %+v err=%v", got, err)
+	}
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if err := app.Run("build", "--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	var comp bytes.Buffer
+	if err := app.GenerateBashCompletion(&comp); err != nil {
+		t.Fatalf("GenerateBashCompletion failed: %v", err)
+	}
+	for _, out := range []string{buf.String(), comp.String()} {
+		if strings.Contains(out, "debug-dump") || strings.Contains(out, "--trace") {
+			t.Fatalf("hidden items listed:\n%s", out)
+		}
+	}
+	if !strings.Contains(buf.String(), "build") || !strings.Contains(buf.String(), "--out") {
+		t.Fatalf("visible items missing:\n%s", buf.String())
+	}
+}
+
+func TestDeprecatedOption(t *testing.T) {
+	type RenameArgs struct {
+		NewName string `long:"--new-name"`
+		OldName string `long:"--old-name" deprecated:"use --new-name"`
+	}
+
+	var out, warn bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &out, LogWarning: &warn})
+	var got RenameArgs
+	app.Add("rename", func(a RenameArgs) { got = a })
+
+	if err := app.Run("rename", "--new-name", "a"); err != nil || warn.Len() != 0 {
+		t.Fatalf("unexpected warning %q or error %v", warn.String(), err)
+	}
+	if err := app.Run("rename", "--old-name", "b"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.OldName != "b" {
+		t.Fatalf("deprecated option was not parsed: %+v", got)
+	}
+	if warn.String() != "--old-name is deprecated: use --new-name\n" {
+		t.Fatalf("unexpected warning: %q", warn.String())
+	}
+
+	if err := app.Run("rename", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	help := out.String()
+	dep := strings.Index(help, "Deprecated:")
+	if dep == -1 || strings.Index(help, "--old-name") < dep || strings.Contains(help, "[--old-name") {
+		t.Fatalf("expected --old-name only in the Deprecated section:\n%s", help)
+	}
+	if !strings.Contains(help, "  --old-name <string>     use --new-name\n") {
+		t.Fatalf("unexpected deprecated entry:\n%s", help)
+	}
+}
+
+func TestExclusiveGroup(t *testing.T) {
+	type ListArgs struct {
+		JSON bool `long:"--json" group:"format" exclusive:"true"`
+		YAML bool `long:"--yaml" group:"format"`
+		All  bool `group:"filter"`
+		Mine bool `group:"filter"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got ListArgs
+	app.Add("list", func(a ListArgs) { got = a })
+
+	if err := app.Run("list", "--yaml", "--all", "--mine"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.YAML || !got.All || !got.Mine {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	err := app.Run("list", "--json", "--yaml")
+	if err == nil || !strings.Contains(err.Error(), "options --json and --yaml cannot be used together") {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+
+	if err := app.Run("list", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--json|--no-json        (not with --yaml)") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+}
+
+func TestUseAndAfterHooks(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+
+	var events []string
+	app.Use(func(cmd string, args []string) error {
+		events = append(events, "use1 "+cmd+" "+strings.Join(args, ","))
+		return nil
+	})
+	app.Use(func(cmd string, args []string) error {
+		events = append(events, "use2")
+		if cmd == "deploy" {
+			return errors.New("deploy is frozen")
+		}
+		return nil
+	})
+	app.After(func(cmd string, err error) {
+		events = append(events, fmt.Sprintf("after %s %v", cmd, err))
+	})
+
+	app.Add("greet", func(name string) { events = append(events, "greet "+name) })
+	deployed := false
+	app.Add("deploy", func() { deployed = true })
+
+	if err := app.Run("greet", "bob"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := []string{"use1 greet bob", "use2", "greet bob", "after greet <nil>"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	events = nil
+	err := app.Run("deploy")
+	if err == nil || err.Error() != "deploy is frozen" || deployed {
+		t.Fatalf("expected the hook to abort: err=%v deployed=%v", err, deployed)
+	}
+	want = []string{"use1 deploy ", "use2", "after deploy deploy is frozen"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	events = nil
+	if err := app.Run("greet"); err == nil {
+		t.Fatalf("expected argument error")
+	}
+	if len(events) != 3 || !strings.HasPrefix(events[2], "after greet wrong number of arguments") {
+		t.Fatalf("expected after hook with the parse error: %v", events)
+	}
+}
+
+func TestStdinValue(t *testing.T) {
+	type WcArgs struct {
+		Text  string `arg:"0" stdin:"true"`
+		Label string
+	}
+
+	app := New(Options{ExitOnError: false, Input: bytes.NewReader([]byte("hello\nworld\n"))})
+	var got WcArgs
+	app.Add("wc", func(a WcArgs) { got = a })
+
+	if err := app.Run("wc", "-", "--label", "-"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Text != "hello\nworld\n" || got.Label != "-" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("wc", "inline"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Text != "inline" {
+		t.Fatalf("expected a literal value, got %q", got.Text)
+	}
+}
+
+func TestParse(t *testing.T) {
+	type CopyArgs struct {
+		Src   string `arg:"0"`
+		Force bool   `short:"f"`
+	}
+
+	app := New(Options{ExitOnError: true})
+	called := false
+	app.Add("copy", func(a *CopyArgs) { called = true })
+	app.Add("add", func(ctx context.Context, a int, b int) { called = true })
+
+	cmd, values, err := app.Parse("copy", "a.txt", "-f")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	args, ok := values[0].(*CopyArgs)
+	if cmd != "copy" || len(values) != 1 || !ok || args.Src != "a.txt" || !args.Force {
+		t.Fatalf("unexpected result: cmd=%q values=%#v", cmd, values)
+	}
+
+	cmd, values, err = app.Parse("add", "1", "2")
+	if err != nil || cmd != "add" || !reflect.DeepEqual(values, []any{1, 2}) {
+		t.Fatalf("unexpected result: cmd=%q values=%v err=%v", cmd, values, err)
+	}
+	if called {
+		t.Fatalf("Parse must not call the handler")
+	}
+
+	if _, _, err := app.Parse("add", "1"); err == nil || !strings.Contains(err.Error(), "wrong number of arguments for add") {
+		t.Fatalf("expected argument count error, got %v", err)
+	}
+	if _, _, err := app.Parse("cpy"); err == nil || !strings.Contains(err.Error(), "Did you mean 'copy'?") {
+		t.Fatalf("expected unknown command error, got %v", err)
+	}
+
+	// where Run would print help, Parse reports ErrHelp
+	for _, args := range [][]string{{}, {"copy", "--help"}, {"-h"}, {"help", "copy"}} {
+		if _, _, err := app.Parse(args...); !errors.Is(err, ErrHelp) {
+			t.Fatalf("Parse(%q): expected ErrHelp, got %v", args, err)
+		}
+	}
+	strict := New(Options{ExitOnError: false, NoArgsBehavior: NoArgsError})
+	strict.Add("copy", func(a *CopyArgs) {})
+	if _, _, err := strict.Parse(); !errors.Is(err, ErrWrongArgCount) {
+		t.Fatalf("expected no command error, got %v", err)
+	}
+}
+
+type commonArgs struct {
+	Config  string `arg:"0" help:"config file"`
+	Verbose bool   `short:"v"`
+}
+
+type LogOptions struct {
+	LogLevel string `default:"info"`
+}
+
+func TestEmbeddedStruct(t *testing.T) {
+	type DeployArgs struct {
+		commonArgs
+		*LogOptions
+		Target string `arg:"1"`
+		DryRun bool
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got DeployArgs
+	app.Add("deploy", func(a DeployArgs) { got = a })
+
+	if err := app.Run("deploy", "app.yaml", "prod", "-v", "--log-level", "debug", "--dry-run"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Config != "app.yaml" || got.Target != "prod" || !got.Verbose || !got.DryRun {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+	if got.LogOptions == nil || got.LogLevel != "debug" {
+		t.Fatalf("embedded pointer struct not set: %+v", got.LogOptions)
+	}
+
+	if err := app.Run("deploy", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"deploy <config> <target>", "[0] config file", "-v|--verbose", "--log-level <string>", "--dry-run"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("help missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestNestedStructOptions(t *testing.T) {
+	type TLSOptions struct {
+		Cert string `help:"certificate file"`
+		Key  string `long:"--key"`
+	}
+	type ServeArgs struct {
+		TLS     TLSOptions
+		Backup  *TLSOptions            `prefix:"backup-tls"`
+		Limits  struct{ MaxConns int } `prefix:""`
+		Verbose bool
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got ServeArgs
+	app.Add("serve", func(a ServeArgs) { got = a })
+
+	if err := app.Run("serve"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Backup != nil {
+		t.Fatalf("expected the unused pointer group to stay nil")
+	}
+
+	err := app.Run("serve", "--tls-cert", "a.pem", "--tls-key", "a.key", "--backup-tls-cert", "b.pem", "--max-conns", "10")
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.TLS.Cert != "a.pem" || got.TLS.Key != "a.key" || got.Backup == nil || got.Backup.Cert != "b.pem" || got.Limits.MaxConns != 10 {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("serve", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--tls-cert <string>           certificate file") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	snake := New(Options{ExitOnError: false, FlagCase: FlagCaseSnake})
+	snake.Add("serve", func(a ServeArgs) { got = a })
+	if err := snake.Run("serve", "--tls_cert", "c.pem"); err != nil || got.TLS.Cert != "c.pem" {
+		t.Fatalf("snake case prefix: %+v err=%v", got, err)
+	}
+}
+
+func TestMapOption(t *testing.T) {
+	type RunArgs struct {
+		Labels map[string]string `long:"--label" help:"container label"`
+		Limits map[string]int
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got RunArgs
+	app.Add("run", func(a RunArgs) { got = a })
+
+	if err := app.Run("run", "--label", "a=b", "--label=c=d=e", "--limits", "cpu=2"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !reflect.DeepEqual(got.Labels, map[string]string{"a": "b", "c": "d=e"}) || !reflect.DeepEqual(got.Limits, map[string]int{"cpu": 2}) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	if err := app.Run("run", "--label", "novalue"); err == nil || !strings.Contains(err.Error(), `expected key=value, got "novalue"`) {
+		t.Fatalf("expected key=value error, got %v", err)
+	}
+	if err := app.Run("run", "--limits", "cpu=x"); err == nil {
+		t.Fatalf("expected parse error for a map value")
+	}
+
+	if err := app.Run("run", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "--label <key=value>...     container label (repeatable)") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+}
+
+func TestSetName(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, Version: "1.2.0"})
+	app.SetName("mytool")
+	app.Add("", func(path string) {})
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Usage:\n  mytool <arg0>\n") {
+		t.Fatalf("expected configured name in usage:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	app = New(Options{ExitOnError: false, Log: &buf, Version: "1.2.0"})
+	app.Add("build", func() {})
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if want := filepath.Base(os.Args[0]) + " 1.2.0\n"; !strings.HasPrefix(buf.String(), want) {
+		t.Fatalf("expected base name of os.Args[0] in header, got:\n%s", buf.String())
+	}
+}
+
+func TestRunRootOnEmpty(t *testing.T) {
+	type ServeArgs struct {
+		Port  int    `default:"8080"`
+		Token string `required:"true"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	ran := false
+	app.Add("", func(a ServeArgs) { ran = true })
+	if err := app.Run([]string{}...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if ran || !strings.Contains(buf.String(), "Usage:") {
+		t.Fatalf("expected root help without running the handler, got %q", buf.String())
+	}
+
+	app = New(Options{ExitOnError: false, Log: &buf, RunRootOnEmpty: true, LookupEnv: func(string) (string, bool) { return "", false }})
+	var got ServeArgs
+	app.Add("", func(a ServeArgs) { got = a })
+	err := app.Run([]string{}...)
+	if err == nil || !strings.Contains(err.Error(), "--token") {
+		t.Fatalf("expected missing required option error, got %v", err)
+	}
+
+	app = New(Options{ExitOnError: false, Log: &buf, RunRootOnEmpty: true})
+	app.Add("", func(a struct {
+		Port int `default:"8080"`
+	}) {
+		got.Port = a.Port
+	})
+	if err := app.Run([]string{}...); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Port != 8080 {
+		t.Fatalf("expected default to be applied, got %d", got.Port)
+	}
+}
+
+func TestShowUsageOnError(t *testing.T) {
+	var out, errOut bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &out, LogError: &errOut, ShowUsageOnError: true})
+	app.Add("add", func(a, b int) {})
+
+	err := app.Run("add", "1")
+	if err == nil || !strings.Contains(err.Error(), "wrong number of arguments for add") {
+		t.Fatalf("expected argument count error, got %v", err)
+	}
+	if !strings.HasPrefix(errOut.String(), "Usage:\n  add <arg0> <arg1>\n") || strings.Contains(errOut.String(), "wrong number") {
+		t.Fatalf("expected only the usage on LogError, got %q", errOut.String())
+	}
+	if out.Len() != 0 {
+		t.Fatalf("expected nothing on Log, got %q", out.String())
+	}
+
+	// with ExitOnError the error follows the usage and is written once
+	errOut.Reset()
+	code := -1
+	app = New(Options{ExitOnError: true, Log: &out, LogError: &errOut, ShowUsageOnError: true, Exit: func(c int) { code = c }})
+	app.Add("add", func(a, b int) {})
+	app.Run("add", "1", "x")
+	got := errOut.String()
+	if code != 1 || !strings.HasPrefix(got, "Usage:\n") || !strings.HasSuffix(got, "\nfailed to parse arg 2 for add: strconv.Atoi: parsing \"x\": invalid syntax\n") || strings.Count(got, "failed to parse") != 1 {
+		t.Fatalf("unexpected error output (code %d):\n%s", code, got)
+	}
+
+	// handler errors do not print the usage
+	errOut.Reset()
+	app = New(Options{ExitOnError: false, Log: &out, LogError: &errOut, ShowUsageOnError: true})
+	app.Add("fail", func() error { return errors.New("boom") })
+	if err := app.Run("fail"); err == nil || errOut.Len() != 0 {
+		t.Fatalf("expected handler error without usage, got %v and %q", err, errOut.String())
+	}
+
+	// the usage goes to LogError without redirecting Log, so help requested
+	// concurrently still reaches Log
+	out.Reset()
+	errOut.Reset()
+	app = New(Options{ExitOnError: false, Log: &out, LogError: &errOut, ShowUsageOnError: true})
+	app.Add("add", func(a, b int) {})
+	app.Add("sub", func(a, b int) {})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			app.Run("add", "1")
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		app.Run("sub", "-h")
+	}
+	<-done
+	if strings.Contains(out.String(), "add <arg0>") || strings.Contains(errOut.String(), "sub <arg0>") {
+		t.Fatalf("help and usage were written to the wrong writer")
+	}
+}
+
+func TestRestArgField(t *testing.T) {
+	type LintArgs struct {
+		Config string   `arg:"0"`
+		Files  []string `arg:"rest"`
+		Fix    bool     `short:"-f"`
+		Level  int      `long:"--level"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got LintArgs
+	app.Add("lint", func(a LintArgs) { got = a })
+
+	if err := app.Run("lint", "cfg.yml", "a.go", "--level", "2", "b.go", "-f", "--", "-c.go"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := LintArgs{Config: "cfg.yml", Files: []string{"a.go", "b.go", "-c.go"}, Fix: true, Level: 2}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	got = LintArgs{}
+	if err := app.Run("lint", "cfg.yml"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Files != nil {
+		t.Fatalf("expected no files, got %q", got.Files)
+	}
+
+	if err := app.Run("lint", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "lint <config> [<files...>] [--fix] [--level <int>]") {
+		t.Fatalf("unexpected usage:\n%s", buf.String())
+	}
+
+	var nums []int
+	app.Add("sum", func(a struct {
+		Nums []int `arg:"rest"`
+	}) {
+		nums = a.Nums
+	})
+	if err := app.Run("sum", "1", "-2", "3"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !slices.Equal(nums, []int{1, -2, 3}) {
+		t.Fatalf("unexpected nums: %v", nums)
+	}
+	if err := app.Run("sum", "1", "x"); err == nil || !strings.Contains(err.Error(), "failed to parse nums") {
+		t.Fatalf("expected parse error, got %v", err)
+	}
+}
+
+func TestShortOnlyOption(t *testing.T) {
+	type GrepArgs struct {
+		IgnoreCase bool   `short:"-i" long:"-" help:"ignore case"`
+		Count      int    `short:"-n" long:"-"`
+		Pattern    string `arg:"0"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	var got GrepArgs
+	app.Add("grep", func(a GrepArgs) { got = a })
+
+	if err := app.Run("grep", "foo", "-i", "-n", "3"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !got.IgnoreCase || got.Count != 3 || got.Pattern != "foo" {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	for _, args := range [][]string{
+		{"grep", "foo", "--ignore-case"},
+		{"grep", "foo", "--no-ignore-case"},
+		{"grep", "foo", "--count", "3"},
+	} {
+		if err := app.Run(args...); !errors.Is(err, ErrUnknownOption) {
+			t.Fatalf("Run(%q): expected unknown option, got %v", args, err)
+		}
+	}
+
+	if err := app.Run("grep", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "grep <pattern> [-i] [-n <int>]") || !strings.Contains(buf.String(), "  -i                      ignore case\n") || strings.Contains(buf.String(), "--ignore-case") {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `has long:"-" but no short option`) {
+			t.Fatalf("expected panic for long:\"-\" without short, got %v", r)
+		}
+	}()
+	app.Add("bad", func(a struct {
+		Quiet bool `long:"-"`
+	}) {
+	})
+}
+
+func TestDuplicateOptionNames(t *testing.T) {
+	type LongArgs struct {
+		Output string `long:"--out"`
+		Out    string
+	}
+	type ShortArgs struct {
+		Verbose bool `short:"v"`
+		Version bool `short:"-v"`
+	}
+	type NegatedArgs struct {
+		Cache   bool
+		NoCache string
+	}
+	type HelpArgs struct {
+		Help bool
+	}
+
+	for _, tc := range []struct {
+		fn   any
+		want string
+	}{
+		{func(a LongArgs) {}, "option --out is declared by both field Output and field Out"},
+		{func(a ShortArgs) {}, "option -v is declared by both field Verbose and field Version"},
+		{func(a NegatedArgs) {}, "option --no-cache is declared by both field Cache and field NoCache"},
+		{func(a HelpArgs) {}, "option --help of field Help collides with the built-in help option"},
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), tc.want) {
+					t.Fatalf("expected panic containing %q, got %v", tc.want, r)
+				}
+			}()
+			New(Options{ExitOnError: false}).Add("run", tc.fn)
+		}()
+	}
+}
+
+func TestUnsupportedHandlerTypes(t *testing.T) {
+	type ServeArgs struct {
+		Port    int
+		Handler func()
+	}
+	type TLSOptions struct {
+		Ciphers map[string][]string
+	}
+	type NestedArgs struct {
+		TLS TLSOptions
+	}
+
+	for _, tc := range []struct {
+		fn   any
+		want string
+	}{
+		{func(n int, c chan int) {}, `unsupported type chan int of parameter 1 for command "run"`},
+		{func(xs ...[]string) {}, `unsupported type [][]string of parameter 0 for command "run"`},
+		{func(a ServeArgs) {}, `unsupported type func() of field Handler of parameter 0 for command "run"`},
+		{func(a *NestedArgs) {}, `unsupported type map[string][]string of field Ciphers of parameter 0 for command "run"`},
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), tc.want) {
+					t.Fatalf("expected panic containing %q, got %v", tc.want, r)
+				}
+			}()
+			New(Options{ExitOnError: false}).Add("run", tc.fn)
+		}()
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("ok", func(ctx context.Context, d time.Duration, names ...string) {})
+}
+
+func TestInvalidTagsPanicAtAdd(t *testing.T) {
+	type RestArgs struct {
+		Rest []int `rest:""`
+	}
+	type ToggleArgs struct {
+		Cache string `toggle:""`
+	}
+	type OnlyWithArgs struct {
+		Level int `only-with:"--compress"`
+	}
+
+	for _, tc := range []struct {
+		fn   any
+		want string
+	}{
+		{func(a RestArgs) {}, `rest field Rest of parameter 0 for command "run" must be []string`},
+		{func(a ToggleArgs) {}, `toggle field Cache of parameter 0 for command "run" must be bool`},
+		{func(a OnlyWithArgs) {}, `only-with on field Level of parameter 0 for command "run" refers to unknown option --compress`},
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), tc.want) {
+					t.Fatalf("expected panic containing %q, got %v", tc.want, r)
+				}
+			}()
+			New(Options{ExitOnError: false}).Add("run", tc.fn)
+		}()
+	}
+}
+
+func TestMultipleStructParams(t *testing.T) {
+	type GlobalOpts struct {
+		Verbose bool   `short:"v"`
+		Config  string `short:"c"`
+	}
+	type CopyOpts struct {
+		Src   string `arg:"0"`
+		Dst   string `arg:"1"`
+		Force bool
+	}
+
+	var g GlobalOpts
+	var c CopyOpts
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("copy", func(a GlobalOpts, b *CopyOpts) { g, c = a, *b })
+
+	for _, args := range [][]string{
+		{"copy", "-v", "--config", "app.json", "a.txt", "b.txt", "--force"},
+		{"copy", "a.txt", "b.txt", "--force", "-capp.json", "--verbose"},
+		{"copy", "a.txt", "-v", "b.txt", "-c", "app.json", "--force"},
+		{"copy", "--force", "a.txt", "-v", "b.txt", "-c", "app.json"},
+	} {
+		g, c = GlobalOpts{}, CopyOpts{}
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+		if !g.Verbose || g.Config != "app.json" || c.Src != "a.txt" || c.Dst != "b.txt" || !c.Force {
+			t.Fatalf("Run(%q): unexpected args: %+v %+v", args, g, c)
+		}
+	}
+
+	// args after -- belong to the last struct
+	g, c = GlobalOpts{}, CopyOpts{}
+	if err := app.Run("copy", "-v", "--", "-a.txt", "--force"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !g.Verbose || c.Src != "-a.txt" || c.Dst != "--force" || c.Force {
+		t.Fatalf("unexpected args after --: %+v %+v", g, c)
+	}
+
+	err := app.Run("copy", "a.txt", "b.txt", "--dry-run")
+	var uoe *UnknownOptionError
+	if !errors.As(err, &uoe) || uoe.Option != "--dry-run" {
+		t.Fatalf("expected unknown option error, got %v", err)
+	}
+
+	if err := app.Run("copy", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	for _, want := range []string{"[0] src", "[1] dst", "--config", "--force"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("help missing %q:\n%s", want, buf.String())
+		}
+	}
+
+	type ForceOpts struct {
+		Force bool `short:"f"`
+	}
+	defer func() {
+		r := recover()
+		if r == nil || !strings.Contains(fmt.Sprint(r), `option --force is declared by both parameter 0 and parameter 1 for command "copy"`) {
+			t.Fatalf("expected panic for option shared by two structs, got %v", r)
+		}
+	}()
+	New(Options{ExitOnError: false}).Add("copy", func(a ForceOpts, b CopyOpts) {})
+}
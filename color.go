@@ -0,0 +1,36 @@
+package cliapp
+
+import "io"
+
+const (
+	ansiReset = "\x1b[0m"
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiCyan  = "\x1b[36m"
+)
+
+// Reports whether output written to w should be colored: Color is set,
+// NO_COLOR is not, and w is a terminal.
+func (a *App) colorEnabled(w io.Writer) bool {
+	if !a.opts.Color {
+		return false
+	}
+	if _, ok := a.opts.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	r, ok := w.(io.Reader)
+	return ok && isTerminal(r)
+}
+
+// Wraps s in the given ANSI code when w is colored
+func (a *App) paint(w io.Writer, code, s string) string {
+	if s == "" || !a.colorEnabled(w) {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// Prints a help section heading such as "Usage:"
+func (a *App) printHeading(w io.Writer, s string) {
+	io.WriteString(w, a.paint(w, ansiBold, s)+"\n")
+}
@@ -0,0 +1,66 @@
+package cliapp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestColor(t *testing.T) {
+	orig := isTerminal
+	defer func() { isTerminal = orig }()
+
+	type BuildArgs struct {
+		Output string `short:"-o" help:"output path"`
+	}
+	run := func(color, terminal bool, env map[string]string, args ...string) (string, string) {
+		isTerminal = func(r io.Reader) bool { return terminal }
+		var out, errOut bytes.Buffer
+		app := New(Options{
+			Log:         &out,
+			LogError:    &errOut,
+			ExitOnError: true,
+			Exit:        func(int) {},
+			Color:       color,
+			LookupEnv: func(key string) (string, bool) {
+				v, ok := env[key]
+				return v, ok
+			},
+		})
+		app.Add("build", "Build it", func(a BuildArgs) error { return errors.New("boom") })
+		app.Run(args...)
+		return out.String(), errOut.String()
+	}
+
+	out, _ := run(true, true, nil, "build", "-h")
+	if !strings.Contains(out, ansiBold+"Usage:"+ansiReset) || !strings.Contains(out, ansiCyan+"-o|--output"+ansiReset+" <string>    output path") {
+		t.Fatalf("expected colored help, got %q", out)
+	}
+	out, _ = run(true, true, nil, "--help")
+	if !strings.Contains(out, "  "+ansiCyan+"build"+ansiReset+"  Build it") {
+		t.Fatalf("expected colored command list, got %q", out)
+	}
+	_, errOut := run(true, true, nil, "build")
+	if errOut != ansiRed+"boom"+ansiReset+"\n" {
+		t.Fatalf("expected red error, got %q", errOut)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		color    bool
+		terminal bool
+		env      map[string]string
+	}{
+		{"disabled", false, true, nil},
+		{"not a terminal", true, false, nil},
+		{"NO_COLOR", true, true, map[string]string{"NO_COLOR": ""}},
+	} {
+		out, _ := run(tc.color, tc.terminal, tc.env, "build", "-h")
+		_, errOut := run(tc.color, tc.terminal, tc.env, "build")
+		if strings.Contains(out+errOut, "\x1b[") {
+			t.Fatalf("%s: unexpected escape codes in %q", tc.name, out+errOut)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package cliapp
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Writes a bash completion script for the registered commands.
+//
+// The script completes command and subcommand names until the first word
+// that is not a known command, and the long options of that command
+// afterwards. Load it with `source <(prog completion)` or install it into
+// the bash-completion directory.
+func (a *App) GenerateBashCompletion(w io.Writer) error {
+	prog := a.programName()
+	fn := "_" + shellIdent(prog) + "_completions"
+
+	tree := a.commandTree(nil)
+	paths := make([]string, 0, len(tree))
+	for _, n := range tree {
+		paths = append(paths, bashQuote(strings.Join(n.tokens, " ")))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# bash completion for %s\n", prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    local cmd=\"\" i\n")
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"${cmd:+$cmd }${COMP_WORDS[i]}\" in\n")
+	if len(paths) > 0 {
+		fmt.Fprintf(&b, "            %s) cmd=\"${cmd:+$cmd }${COMP_WORDS[i]}\" ;;\n", strings.Join(paths, "|"))
+	}
+	b.WriteString("            *) break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n")
+	a.writeBashValueCompletion(&b)
+	b.WriteString("    case \"$cmd\" in\n")
+
+	rootWords := a.completionChildren(tree, nil)
+	rootWords = append(rootWords, a.completionOptions(a.root)...)
+	if a.hasVersion() {
+		rootWords = append(rootWords, "--version")
+	}
+	writeBashCase(&b, "", rootWords)
+	for _, n := range tree {
+		words := append(a.completionChildren(tree, n.tokens), a.completionOptions(n.h)...)
+		writeBashCase(&b, strings.Join(n.tokens, " "), words)
+	}
+
+	b.WriteString("    esac\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -F %s %s\n", fn, prog)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the cases that complete the values of options registered with
+// CompleteFunc by running the program with __complete
+func (a *App) writeBashValueCompletion(b *strings.Builder) {
+	if len(a.completers) == 0 {
+		return
+	}
+	var keys []string
+	for cmd, opts := range a.completers {
+		for opt := range opts {
+			keys = append(keys, bashQuote(cmd+":"+opt))
+		}
+	}
+	sort.Strings(keys)
+	b.WriteString("    case \"$cmd:${COMP_WORDS[COMP_CWORD-1]}\" in\n")
+	fmt.Fprintf(b, "        %s)\n", strings.Join(keys, "|"))
+	fmt.Fprintf(b, "            COMPREPLY=($(\"${COMP_WORDS[0]}\" %s \"${COMP_WORDS[@]:1:COMP_CWORD}\"))\n", completeCommand)
+	b.WriteString("            return ;;\n")
+	b.WriteString("    esac\n")
+}
+
+func writeBashCase(b *strings.Builder, path string, words []string) {
+	fmt.Fprintf(b, "        %s) COMPREPLY=($(compgen -W %s -- \"$cur\")) ;;\n", bashQuote(path), bashQuote(strings.Join(words, " ")))
+}
+
+// Returns the names of the direct subcommands of prefix.
+func (a *App) completionChildren(tree []commandNode, prefix []string) []string {
+	var names []string
+	for _, n := range tree {
+		if len(n.tokens) == len(prefix)+1 && slices.Equal(n.tokens[:len(prefix)], prefix) {
+			names = append(names, n.tokens[len(prefix)])
+		}
+	}
+	return names
+}
+
+// Returns the long option names accepted by a command, including the long
+// help flags. A nil handler (an intermediate group) only accepts those.
+func (a *App) completionOptions(h *handler) []string {
+	var names []string
+	if h != nil {
+		for _, o := range a.handlerOptions(*h) {
+			if o.hidden || o.deprecated != "" {
+				continue
+			}
+			names = append(names, o.long)
+			if o.negated != "" {
+				names = append(names, o.negated)
+			}
+		}
+	}
+	return append(names, a.longHelpFlags()...)
+}
+
+// Returns the help flags that are long options, such as --help
+func (a *App) longHelpFlags() []string {
+	var flags []string
+	for _, flag := range a.helpFlags() {
+		if strings.HasPrefix(flag, "--") {
+			flags = append(flags, flag)
+		}
+	}
+	return flags
+}
+
+// Replaces characters that are not valid in a shell function name.
+func shellIdent(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Quotes s as a single-quoted shell word.
+func bashQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Writes a zsh completion script for the registered commands.
+//
+// Commands are described with their help text, and each command's options
+// and positional arguments become `_arguments` specs. Place the output in a
+// file named `_prog` on $fpath.
+func (a *App) GenerateZshCompletion(w io.Writer) error {
+	prog := a.programName()
+	fn := "_" + shellIdent(prog)
+
+	tree := a.commandTree(nil)
+	paths := make([]string, 0, len(tree))
+	for _, n := range tree {
+		paths = append(paths, bashQuote(strings.Join(n.tokens, " ")))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#compdef %s\n\n", prog)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cmd=\"\" i\n")
+	b.WriteString("    for ((i = 2; i < CURRENT; i++)); do\n")
+	b.WriteString("        case \"${cmd:+$cmd }${words[i]}\" in\n")
+	if len(paths) > 0 {
+		fmt.Fprintf(&b, "            %s) cmd=\"${cmd:+$cmd }${words[i]}\" ;;\n", strings.Join(paths, "|"))
+	}
+	b.WriteString("            *) break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n")
+	b.WriteString("    (( CURRENT -= i - 2 ))\n")
+	b.WriteString("    shift $(( i - 2 )) words\n")
+	b.WriteString("    case \"$cmd\" in\n")
+
+	rootSpecs := a.zshSpecs(tree, nil, a.root)
+	if a.hasVersion() {
+		rootSpecs = append(rootSpecs, bashQuote("--version[Show version information]"))
+	}
+	writeZshCase(&b, "", rootSpecs)
+	for _, n := range tree {
+		writeZshCase(&b, strings.Join(n.tokens, " "), a.zshSpecs(tree, n.tokens, n.h))
+	}
+
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "%s \"$@\"\n", fn)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeZshCase(b *strings.Builder, path string, specs []string) {
+	fmt.Fprintf(b, "        %s)\n", bashQuote(path))
+	b.WriteString("            _arguments -s")
+	for _, s := range specs {
+		b.WriteString(" \\\n                " + s)
+	}
+	b.WriteString("\n            ;;\n")
+}
+
+// Returns the quoted `_arguments` specs for the command at path. Commands
+// with subcommands complete those at the first position instead of their
+// own positional arguments.
+func (a *App) zshSpecs(tree []commandNode, path []string, h *handler) []string {
+	var specs []string
+	if h != nil {
+		opts := a.handlerOptions(*h)
+		sort.Slice(opts, func(i, j int) bool { return opts[i].long < opts[j].long })
+		for _, o := range opts {
+			if o.hidden || o.deprecated != "" {
+				continue
+			}
+			specs = append(specs, zshOptionSpec(o)...)
+		}
+	}
+	for _, flag := range a.longHelpFlags() {
+		specs = append(specs, bashQuote("(- *)"+flag+"[Show this help]"))
+	}
+
+	var children []string
+	for _, n := range tree {
+		if len(n.tokens) == len(path)+1 && slices.Equal(n.tokens[:len(path)], path) {
+			item := zshEscape(n.tokens[len(path)])
+			if n.h != nil && n.h.help != "" {
+				item += `\:"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(n.h.help) + `"`
+			}
+			children = append(children, item)
+		}
+	}
+	if len(children) > 0 {
+		return append(specs, bashQuote("1:command:(("+strings.Join(children, " ")+"))"))
+	}
+	if h == nil {
+		return specs
+	}
+
+	if ps := handlerPositionals(*h); len(ps) > 0 {
+		for _, p := range ps {
+			sep := ":"
+			if p.optional {
+				sep = "::"
+			}
+			specs = append(specs, bashQuote(fmt.Sprintf("%d%s%s:", p.pos+1, sep, zshEscape(p.name))))
+		}
+		return specs
+	}
+	for i, t := range h.targs {
+		if _, ok := structParam(t); ok {
+			continue
+		}
+		pos := strconv.Itoa(i + 1)
+		if h.isVariadicArg(i) {
+			pos = "*"
+		} else if h.isOptionalArg(i) {
+			pos += ":"
+		}
+		specs = append(specs, bashQuote(fmt.Sprintf("%s:arg%d %s:", pos, i, zshEscape(h.argLabel(i)))))
+	}
+	return specs
+}
+
+// Returns the quoted `_arguments` specs for a single option.
+func zshOptionSpec(o optionSpec) []string {
+	desc := "[" + zshEscape(o.help) + "]"
+	if !o.isFlag {
+		desc += ":" + zshEscape(strings.Trim(o.typeLabel, "<>.")) + ":"
+		if len(o.choices) > 0 {
+			choices := make([]string, len(o.choices))
+			for i, c := range o.choices {
+				choices[i] = zshEscape(c)
+			}
+			desc += "(" + strings.Join(choices, " ") + ")"
+		}
+	}
+	repeat := ""
+	if o.repeatable || o.count {
+		repeat = "*"
+	}
+
+	var specs []string
+	if o.short != "" {
+		excl := "(" + o.short + " " + o.long + ")"
+		if repeat != "" {
+			excl = ""
+		}
+		specs = append(specs, bashQuote(excl+repeat)+"{"+o.short+","+o.long+"}"+bashQuote(desc))
+	} else {
+		specs = append(specs, bashQuote(repeat+o.long+desc))
+	}
+	if o.negated != "" {
+		specs = append(specs, bashQuote(o.negated+"["+zshEscape("Disable "+o.long)+"]"))
+	}
+	return specs
+}
+
+// Escapes the characters that are special inside `_arguments` specs.
+func zshEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`).Replace(s)
+}
+
+// Writes a fish completion script for the registered commands.
+//
+// Commands complete as the first argument and subcommands after their
+// parent; options, with their descriptions, complete once a command has been
+// given. Place the output in ~/.config/fish/completions/prog.fish.
+func (a *App) GenerateFishCompletion(w io.Writer) error {
+	prog := a.programName()
+	tree := a.commandTree(nil)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# fish completion for %s\n", prog)
+	fmt.Fprintf(&b, "complete -c %s -f\n", fishQuote(prog))
+
+	// root: subcommands and options before any command
+	for _, n := range tree {
+		if len(n.tokens) == 1 {
+			writeFishCommand(&b, prog, "__fish_use_subcommand", n)
+		}
+	}
+	a.writeFishOptions(&b, prog, "__fish_use_subcommand", a.root)
+	if a.hasVersion() {
+		fmt.Fprintf(&b, "complete -c %s -n %s -l version -d %s\n", fishQuote(prog), fishQuote("__fish_use_subcommand"), fishQuote("Show version information"))
+	}
+
+	for _, n := range tree {
+		cond := fishSeen(n.tokens)
+		for _, c := range tree {
+			if len(c.tokens) == len(n.tokens)+1 && slices.Equal(c.tokens[:len(n.tokens)], n.tokens) {
+				writeFishCommand(&b, prog, cond, c)
+			}
+		}
+		a.writeFishOptions(&b, prog, cond, n.h)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeFishCommand(b *strings.Builder, prog, cond string, n commandNode) {
+	fmt.Fprintf(b, "complete -c %s -n %s -a %s", fishQuote(prog), fishQuote(cond), fishQuote(n.tokens[len(n.tokens)-1]))
+	if n.h != nil && n.h.help != "" {
+		fmt.Fprintf(b, " -d %s", fishQuote(n.h.help))
+	}
+	b.WriteString("\n")
+}
+
+// Writes the options of a command, sorted by long name. A nil handler (an
+// intermediate group) only has --help.
+func (a *App) writeFishOptions(b *strings.Builder, prog, cond string, h *handler) {
+	if flags := a.helpFlags(); len(flags) > 0 {
+		fmt.Fprintf(b, "complete -c %s -n %s", fishQuote(prog), fishQuote(cond))
+		for _, flag := range flags {
+			switch {
+			case strings.HasPrefix(flag, "--"):
+				fmt.Fprintf(b, " -l %s", strings.TrimPrefix(flag, "--"))
+			case utf8.RuneCountInString(flag) == 2:
+				fmt.Fprintf(b, " -s %s", strings.TrimPrefix(flag, "-"))
+			default:
+				fmt.Fprintf(b, " -o %s", strings.TrimPrefix(flag, "-"))
+			}
+		}
+		fmt.Fprintf(b, " -d %s\n", fishQuote("Show this help"))
+	}
+	if h == nil {
+		return
+	}
+	opts := a.handlerOptions(*h)
+	sort.Slice(opts, func(i, j int) bool { return opts[i].long < opts[j].long })
+	for _, o := range opts {
+		if o.hidden || o.deprecated != "" {
+			continue
+		}
+		fmt.Fprintf(b, "complete -c %s -n %s", fishQuote(prog), fishQuote(cond))
+		if o.short != "" && utf8.RuneCountInString(o.short) == 2 {
+			fmt.Fprintf(b, " -s %s", fishQuote(strings.TrimPrefix(o.short, "-")))
+		}
+		fmt.Fprintf(b, " -l %s", fishQuote(strings.TrimPrefix(o.long, "--")))
+		if !o.isFlag {
+			if len(o.choices) > 0 {
+				fmt.Fprintf(b, " -x -a %s", fishQuote(strings.Join(o.choices, " ")))
+			} else {
+				b.WriteString(" -r")
+			}
+		}
+		if o.help != "" {
+			fmt.Fprintf(b, " -d %s", fishQuote(o.help))
+		}
+		b.WriteString("\n")
+		if o.negated != "" {
+			fmt.Fprintf(b, "complete -c %s -n %s -l %s -d %s\n", fishQuote(prog), fishQuote(cond), fishQuote(strings.TrimPrefix(o.negated, "--")), fishQuote("Disable "+o.long))
+		}
+	}
+}
+
+// Returns a fish condition that is true once every token has been given
+func fishSeen(tokens []string) string {
+	conds := make([]string, len(tokens))
+	for i, tok := range tokens {
+		conds[i] = "__fish_seen_subcommand_from " + tok
+	}
+	return strings.Join(conds, "; and ")
+}
+
+// Quotes s as a single-quoted fish word
+func fishQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// Writes a PowerShell completion script for the registered commands.
+//
+// The script registers an argument completer that suggests the subcommands
+// of the command typed so far, followed by its options, with their help
+// text as tooltips. Load it from the PowerShell profile with
+// `prog completion powershell | Out-String | Invoke-Expression`.
+func (a *App) GeneratePowerShellCompletion(w io.Writer) error {
+	prog := a.programName()
+	tree := a.commandTree(nil)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# powershell completion for %s\n", prog)
+	fmt.Fprintf(&b, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", psQuote(prog))
+	b.WriteString("    param($wordToComplete, $commandAst, $cursorPosition)\n")
+	b.WriteString("    $completions = @{\n")
+
+	rootWords := a.psCommandWords(tree, nil)
+	rootWords = append(rootWords, a.psOptionWords(a.root)...)
+	if a.hasVersion() {
+		rootWords = append(rootWords, [2]string{"--version", "Show version information"})
+	}
+	writePSEntry(&b, "", rootWords)
+	for _, n := range tree {
+		writePSEntry(&b, strings.Join(n.tokens, " "), append(a.psCommandWords(tree, n.tokens), a.psOptionWords(n.h)...))
+	}
+	b.WriteString("    }\n")
+
+	b.WriteString("    $words = @($commandAst.CommandElements | Select-Object -Skip 1 | ForEach-Object { $_.ToString() })\n")
+	b.WriteString("    if ($wordToComplete -ne '') { $words = @($words | Select-Object -SkipLast 1) }\n")
+	b.WriteString("    $cmd = ''\n")
+	b.WriteString("    foreach ($word in $words) {\n")
+	b.WriteString("        $next = if ($cmd) { \"$cmd $word\" } else { $word }\n")
+	b.WriteString("        if (-not $completions.ContainsKey($next)) { break }\n")
+	b.WriteString("        $cmd = $next\n")
+	b.WriteString("    }\n")
+	b.WriteString("    $completions[$cmd] | Where-Object { $_[0] -like \"$wordToComplete*\" } | ForEach-Object {\n")
+	b.WriteString("        [System.Management.Automation.CompletionResult]::new($_[0], $_[0], 'ParameterValue', $_[1])\n")
+	b.WriteString("    }\n")
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writePSEntry(b *strings.Builder, path string, words [][2]string) {
+	fmt.Fprintf(b, "        %s = @(\n", psQuote(path))
+	for _, w := range words {
+		fmt.Fprintf(b, "            ,@(%s, %s)\n", psQuote(w[0]), psQuote(w[1]))
+	}
+	b.WriteString("        )\n")
+}
+
+// Returns the direct subcommands of prefix with their help text. A command
+// without help uses its name as the tooltip, which may not be empty.
+func (a *App) psCommandWords(tree []commandNode, prefix []string) [][2]string {
+	var words [][2]string
+	for _, n := range tree {
+		if len(n.tokens) == len(prefix)+1 && slices.Equal(n.tokens[:len(prefix)], prefix) {
+			name := n.tokens[len(prefix)]
+			desc := name
+			if n.h != nil && n.h.help != "" {
+				desc = n.h.help
+			}
+			words = append(words, [2]string{name, desc})
+		}
+	}
+	return words
+}
+
+// Returns the long options of a command sorted by name, with their help
+// text, followed by --help
+func (a *App) psOptionWords(h *handler) [][2]string {
+	var words [][2]string
+	if h != nil {
+		opts := a.handlerOptions(*h)
+		sort.Slice(opts, func(i, j int) bool { return opts[i].long < opts[j].long })
+		for _, o := range opts {
+			if o.hidden || o.deprecated != "" {
+				continue
+			}
+			desc := o.help
+			if desc == "" {
+				desc = o.long
+			}
+			words = append(words, [2]string{o.long, desc})
+			if o.negated != "" {
+				words = append(words, [2]string{o.negated, "Disable " + o.long})
+			}
+		}
+	}
+	for _, flag := range a.longHelpFlags() {
+		words = append(words, [2]string{flag, "Show this help"})
+	}
+	return words
+}
+
+// Quotes s as a single-quoted PowerShell string
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// hidden first argument the completion scripts run the program with to
+// complete values at runtime
+const completeCommand = "__complete"
+
+// Registers a function completing the values of a long option of a command,
+// e.g. `app.CompleteFunc("checkout", "--branch", listBranches)`.
+//
+// Running the program as `prog __complete <words...>` with the words typed
+// so far prints the candidates starting with the last word, one per line.
+// The bash script generated by GenerateBashCompletion does so when the
+// previous word is such an option.
+func (a *App) CompleteFunc(cmd, option string, fn func(prefix string) []string) {
+	h, ok := a.lookup(cmd)
+	if !ok {
+		panic(fmt.Sprintf("cannot register completion for %s of unknown command %q", option, cmd))
+	}
+	cmd = strings.Join(h.tokens, " ")
+	if h.alias != "" {
+		cmd = h.alias
+	}
+	if a.completers == nil {
+		a.completers = map[string]map[string]func(prefix string) []string{}
+	}
+	if a.completers[cmd] == nil {
+		a.completers[cmd] = map[string]func(prefix string) []string{}
+	}
+	a.completers[cmd][option] = fn
+}
+
+// Prints the completion candidates for the last of words to Log, one per
+// line: values from a CompleteFunc for the option being given, option names
+// for a word starting with "-", and subcommand names right after a command
+// path.
+func (a *App) complete(words []string) {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur, prev := words[len(words)-1], words[:len(words)-1]
+	// global options before the command do not take part in matching it
+	prev = prev[a.leadingGlobals(prev):]
+	name, h, n := a.match(prev)
+	var hp *handler
+	if n > 0 {
+		hp = &h
+	} else {
+		name, hp = "", a.root
+	}
+
+	option, prefix := "", cur
+	if opt, val, ok := strings.Cut(cur, "="); ok && strings.HasPrefix(opt, "--") {
+		option, prefix = opt, val
+	} else if len(prev) > n && strings.HasPrefix(prev[len(prev)-1], "-") {
+		option = prev[len(prev)-1]
+	}
+	if fn, ok := a.completers[name][option]; ok {
+		a.printCandidates(fn(prefix), prefix)
+		return
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		// a group path such as "remote" only has --help
+		if a.groupLen(prev) > n {
+			hp = nil
+		}
+		a.printCandidates(a.completionOptions(hp), cur)
+		return
+	}
+	if path := max(n, a.groupLen(prev)); path == len(prev) {
+		a.printCandidates(a.completionChildren(a.commandTree(nil), prev), cur)
+	}
+}
+
+// Prints the candidates starting with prefix to Log, one per line
+func (a *App) printCandidates(candidates []string, prefix string) {
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			fmt.Fprintln(a.opts.Log, c)
+		}
+	}
+}
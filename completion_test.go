@@ -0,0 +1,274 @@
+package cliapp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestGenerateBashCompletion(t *testing.T) {
+	type BuildArgs struct {
+		Out     string `short:"o"`
+		Verbose bool
+		Cache   bool `toggle:""`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("build", func(args BuildArgs) {})
+	app.Add("remote add", func(name string) {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateBashCompletion(&buf); err != nil {
+		t.Fatalf("GenerateBashCompletion failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`'build'|'remote'|'remote add')`,
+		`'') COMPREPLY=($(compgen -W 'build remote --help' -- "$cur"))`,
+		`'build') COMPREPLY=($(compgen -W '--out --verbose --no-verbose --cache --no-cache --help' -- "$cur"))`,
+		`'remote') COMPREPLY=($(compgen -W 'add --help' -- "$cur"))`,
+		"complete -F _",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("completion missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestGenerateZshCompletion(t *testing.T) {
+	type BuildArgs struct {
+		Target  string   `arg:"0" help:"build target"`
+		Out     string   `short:"o" help:"output path"`
+		Tags    []string `help:"build tags"`
+		Verbose bool     `help:"verbose: more output"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("build", "Build a target", func(args BuildArgs) {})
+	app.Add("remote add", "Add a remote", func(name string) {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateZshCompletion(&buf); err != nil {
+		t.Fatalf("GenerateZshCompletion failed: %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "#compdef ") {
+		t.Fatalf("missing #compdef header:\n%s", out)
+	}
+	for _, want := range []string{
+		`'1:command:((build\:"Build a target" remote))'`,
+		`'1:command:((add\:"Add a remote"))'`,
+		`'(-o --out)'{-o,--out}'[output path]:string:'`,
+		`'*--tags[build tags]:string:'`,
+		`'--verbose[verbose\: more output]'`,
+		`'1:build target:'`,
+		`'1:arg0 <string>:'`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("completion missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "--out") > strings.Index(out, "--tags") || strings.Index(out, "--tags") > strings.Index(out, "--verbose") {
+		t.Fatalf("options are not sorted:\n%s", out)
+	}
+}
+
+func TestGenerateFishCompletion(t *testing.T) {
+	type BuildArgs struct {
+		Out     string `short:"o" help:"output path"`
+		Verbose bool
+		Mode    string `choices:"fast,safe"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.SetName("mytool")
+	app.Add("build", "Build the project", func(args BuildArgs) {})
+	app.Add("remote add", func(name string) {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateFishCompletion(&buf); err != nil {
+		t.Fatalf("GenerateFishCompletion failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"complete -c 'mytool' -n '__fish_use_subcommand' -a 'build' -d 'Build the project'\n",
+		"complete -c 'mytool' -n '__fish_use_subcommand' -a 'remote'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from remote' -a 'add'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from build' -s 'o' -l 'out' -r -d 'output path'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from build' -l 'mode' -x -a 'fast safe'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from build' -l 'verbose'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from build' -l 'no-verbose' -d 'Disable --verbose'\n",
+		"complete -c 'mytool' -n '__fish_seen_subcommand_from remote; and __fish_seen_subcommand_from add' -s h -l help -d 'Show this help'\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("completion missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, "-l 'mode'") > strings.Index(out, "-l 'out'") {
+		t.Fatalf("expected options sorted by name:\n%s", out)
+	}
+}
+
+func TestGeneratePowerShellCompletion(t *testing.T) {
+	type BuildArgs struct {
+		Out     string `short:"o" help:"output path"`
+		Verbose bool
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.SetName("mytool")
+	app.Add("build", "Build the project", func(args BuildArgs) {})
+	app.Add("remote add", func(name string) {})
+	app.Add("tag", "Tag 'latest'", func() {})
+
+	var buf bytes.Buffer
+	if err := app.GeneratePowerShellCompletion(&buf); err != nil {
+		t.Fatalf("GeneratePowerShellCompletion failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"Register-ArgumentCompleter -Native -CommandName 'mytool' -ScriptBlock {\n",
+		"        '' = @(\n            ,@('build', 'Build the project')\n            ,@('remote', 'remote')\n            ,@('tag', 'Tag ''latest''')\n            ,@('--help', 'Show this help')\n        )\n",
+		"        'build' = @(\n            ,@('--out', 'output path')\n            ,@('--verbose', '--verbose')\n            ,@('--no-verbose', 'Disable --verbose')\n            ,@('--help', 'Show this help')\n        )\n",
+		"        'remote' = @(\n            ,@('add', 'add')\n",
+		"        'remote add' = @(\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("completion missing %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestCompleteFunc(t *testing.T) {
+	type CheckoutArgs struct {
+		Branch string `short:"-b"`
+		Force  bool
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("checkout", func(args CheckoutArgs) {})
+	app.Alias("co", "checkout")
+	var prefixes []string
+	app.CompleteFunc("checkout", "--branch", func(prefix string) []string {
+		prefixes = append(prefixes, prefix)
+		return []string{"main", "feature/a", "feature/b"}
+	})
+	app.CompleteFunc("checkout", "-b", func(prefix string) []string { return []string{"main"} })
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"checkout", "--branch", "fea"}, "feature/a\nfeature/b\n"},
+		{[]string{"checkout", "--branch", ""}, "main\nfeature/a\nfeature/b\n"},
+		{[]string{"checkout", "--branch=m"}, "main\n"},
+		{[]string{"co", "--force", "--branch", "main"}, "main\n"},
+		{[]string{"checkout", "-b", ""}, "main\n"},
+		{[]string{"checkout", "--force", "x"}, ""},
+	} {
+		buf.Reset()
+		if err := app.Run(append([]string{"__complete"}, tc.args...)...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", tc.args, err)
+		}
+		if buf.String() != tc.want {
+			t.Fatalf("__complete %q: got %q, want %q", tc.args, buf.String(), tc.want)
+		}
+	}
+	if prefixes[0] != "fea" {
+		t.Fatalf("expected the prefix to be passed to the function, got %q", prefixes)
+	}
+
+	buf.Reset()
+	if err := app.GenerateBashCompletion(&buf); err != nil {
+		t.Fatalf("GenerateBashCompletion failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), `'checkout:--branch'|'checkout:-b')`) || !strings.Contains(buf.String(), `"${COMP_WORDS[0]}" __complete "${COMP_WORDS[@]:1:COMP_CWORD}"`) {
+		t.Fatalf("expected value completion in bash script:\n%s", buf.String())
+	}
+}
+
+func TestCompleteCommand(t *testing.T) {
+	type CreateArgs struct {
+		Out    string `short:"-o"`
+		Owner  string
+		Secret string `hidden:""`
+		Dry    bool
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.Add("create", func(args CreateArgs) {})
+	app.Add("clean", func() {})
+	app.Add("remote add", func(name string) {})
+	app.Add("remote remove", func(name string) {})
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"create", "--o"}, "--out\n--owner\n"},
+		{[]string{"create", "--d"}, "--dry\n"},
+		{[]string{"create", "--"}, "--out\n--owner\n--dry\n--no-dry\n--help\n"},
+		{[]string{"c"}, "clean\ncreate\n"},
+		{[]string{""}, "clean\ncreate\nremote\n"},
+		{[]string{"remote", "re"}, "remove\n"},
+		{[]string{"remote", "--"}, "--help\n"},
+		{[]string{"create", "x"}, ""},
+		{nil, "clean\ncreate\nremote\n"},
+	} {
+		buf.Reset()
+		if err := app.Run(append([]string{"__complete"}, tc.args...)...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", tc.args, err)
+		}
+		if buf.String() != tc.want {
+			t.Fatalf("__complete %q: got %q, want %q", tc.args, buf.String(), tc.want)
+		}
+	}
+}
+
+func TestCompletionOptionDescriptions(t *testing.T) {
+	type BuildArgs struct {
+		Out   string `short:"o" help:"output path"`
+		Quiet bool   `help:"less output"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.SetName("mytool")
+	app.Add("build", func(args BuildArgs) {})
+
+	for _, tc := range []struct {
+		generate func(*bytes.Buffer) error
+		want     []string
+	}{
+		// zsh and fish show the help text of each option
+		{func(b *bytes.Buffer) error { return app.GenerateZshCompletion(b) }, []string{
+			`'(-o --out)'{-o,--out}'[output path]:string:'`,
+			`'--quiet[less output]'`,
+		}},
+		{func(b *bytes.Buffer) error { return app.GenerateFishCompletion(b) }, []string{
+			"-s 'o' -l 'out' -r -d 'output path'\n",
+			"-l 'quiet' -d 'less output'\n",
+		}},
+		// bash completes the names only
+		{func(b *bytes.Buffer) error { return app.GenerateBashCompletion(b) }, []string{
+			`'build') COMPREPLY=($(compgen -W '--out --quiet --no-quiet --help' -- "$cur"))`,
+		}},
+	} {
+		var buf bytes.Buffer
+		if err := tc.generate(&buf); err != nil {
+			t.Fatalf("generating completion failed: %v", err)
+		}
+		for _, want := range tc.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Fatalf("completion missing %q:\n%s", want, buf.String())
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	app.GenerateBashCompletion(&buf)
+	if strings.Contains(buf.String(), "output path") {
+		t.Fatalf("expected no descriptions in bash completion:\n%s", buf.String())
+	}
+}
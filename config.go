@@ -0,0 +1,116 @@
+package cliapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Fills options that were not given on the command line or through the
+// environment from a config file, so that defaults only apply to options the
+// file leaves out. The file is JSON unless Options.ConfigDecoder is set.
+//
+// The file is named by the option tagged `configfile` when it is given on
+// the command line, the environment or its default, and by
+// Options.ConfigFile otherwise. Only a file named on the command line or in
+// the environment must exist; a missing default or Options.ConfigFile is
+// ignored.
+// Keys are the long option names without dashes, or the `config` tag;
+// `config:"-"` keeps an option out of the file.
+//
+// Values apply with the precedence command line > environment > config file
+// > `default` tag. A key that is present sets its option even to a zero
+// value, so defaults no longer apply to it; an absent key leaves the option
+// unset, and pointer fields stay nil.
+func (a *App) applyConfig(sv reflect.Value, fields []reflect.StructField, set map[int]bool) error {
+	path, explicit := a.configPath(sv, fields, set)
+	if path == "" {
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		if !explicit && errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer file.Close()
+	decode := a.opts.ConfigDecoder
+	if decode == nil {
+		decode = decodeJSON
+	}
+	var values map[string]any
+	if err := decode(file, &values); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	for i, f := range fields {
+		if set[i] || !isOptionField(f) {
+			continue
+		}
+		key := strings.TrimLeft(a.optionName(f), "-")
+		if k, ok := f.Tag.Lookup("config"); ok {
+			key = k
+		}
+		v, ok := values[key]
+		if !ok || key == "-" {
+			continue
+		}
+		if err := a.setConfigField(sv, f, v); err != nil {
+			return fmt.Errorf("invalid value for %s in config file %s: %w", key, path, err)
+		}
+		set[i] = true
+	}
+	return nil
+}
+
+// Returns the config file to load and whether it was given explicitly, on
+// the command line or in the environment, rather than by a default.
+func (a *App) configPath(sv reflect.Value, fields []reflect.StructField, set map[int]bool) (string, bool) {
+	for i, f := range fields {
+		if _, ok := f.Tag.Lookup("configfile"); !ok {
+			continue
+		}
+		if set[i] {
+			return fmt.Sprint(reflect.Indirect(fieldValue(sv, f.Index))), true
+		}
+		if def, ok := f.Tag.Lookup("default"); ok && def != "" {
+			return def, false
+		}
+	}
+	return a.opts.ConfigFile, false
+}
+
+// The default Options.ConfigDecoder
+func decodeJSON(r io.Reader, v any) error {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+// Sets a field from a decoded config value. Strings are parsed like command
+// line values so that durations, choices and self-parsing types work; other
+// values are converted through JSON and then checked against `choices`,
+// `min` and `max`.
+func (a *App) setConfigField(sv reflect.Value, f reflect.StructField, v any) error {
+	fv := fieldValue(sv, f.Index)
+	if s, ok := v.(string); ok && fv.Kind() != reflect.Slice && fv.Kind() != reflect.Map {
+		return a.setField(sv, f, s)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, fv.Addr().Interface()); err != nil {
+		return err
+	}
+	if err := a.checkChoices(f, fv); err != nil {
+		return err
+	}
+	return a.checkRange(f, fv)
+}
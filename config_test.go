@@ -0,0 +1,182 @@
+package cliapp
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigFile(t *testing.T) {
+	type ServeArgs struct {
+		Config  string        `configfile:"" long:"--config"`
+		Host    string        `default:"localhost"`
+		Port    int           `default:"8080" env:"PORT"`
+		Timeout time.Duration `default:"5s"`
+		Tags    []string
+		Debug   bool
+		Secret  string `config:"-"`
+		Name    string `config:"server_name"`
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "serve.json")
+	config := `{"host": "example.com", "port": 9000, "timeout": "30s", "tags": ["a", "b"], "debug": true, "secret": "x", "server_name": "api"}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{}
+	app := New(Options{ExitOnError: false, ConfigFile: path, LookupEnv: func(key string) (string, bool) {
+		v, ok := env[key]
+		return v, ok
+	}})
+	var got ServeArgs
+	app.Add("serve", func(a ServeArgs) { got = a })
+
+	// config overrides defaults
+	if err := app.Run("serve"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := ServeArgs{Host: "example.com", Port: 9000, Timeout: 30 * time.Second, Tags: []string{"a", "b"}, Debug: true, Name: "api"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+
+	// the command line and the environment override the config
+	env["PORT"] = "7000"
+	if err := app.Run("serve", "--host", "cli.example.com"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Host != "cli.example.com" || got.Port != 7000 {
+		t.Fatalf("unexpected precedence: %+v", got)
+	}
+
+	// --config picks another file, which must exist
+	other := filepath.Join(dir, "other.json")
+	if err := os.WriteFile(other, []byte(`{"host": "other.example.com"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Run("serve", "--config", other); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Host != "other.example.com" || got.Timeout != 5*time.Second {
+		t.Fatalf("unexpected args from --config: %+v", got)
+	}
+	if err := app.Run("serve", "--config", filepath.Join(dir, "missing.json")); err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Fatalf("expected missing config error, got %v", err)
+	}
+
+	// a missing Options.ConfigFile is ignored
+	app = New(Options{ExitOnError: false, ConfigFile: filepath.Join(dir, "missing.json")})
+	app.Add("serve", func(a ServeArgs) { got = a })
+	if err := app.Run("serve"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Host != "localhost" {
+		t.Fatalf("expected defaults without a config file, got %+v", got)
+	}
+
+	// so is a missing default of the configfile option
+	type DefaultPathArgs struct {
+		Config string `configfile:"" default:"missing.json"`
+		Host   string `default:"localhost"`
+	}
+	var fromDefault DefaultPathArgs
+	app = New(Options{ExitOnError: false})
+	app.Add("serve", func(a DefaultPathArgs) { fromDefault = a })
+	if err := app.Run("serve"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if fromDefault.Host != "localhost" {
+		t.Fatalf("expected defaults without a config file, got %+v", fromDefault)
+	}
+	if err := app.Run("serve", "--config", "missing.json"); err == nil || !strings.Contains(err.Error(), "failed to read config file") {
+		t.Fatalf("expected missing config error, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte(`{"port": "x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app = New(Options{ExitOnError: false, ConfigFile: path})
+	app.Add("serve", func(a ServeArgs) {})
+	if err := app.Run("serve"); err == nil || !strings.Contains(err.Error(), "invalid value for port in config file") {
+		t.Fatalf("expected invalid value error, got %v", err)
+	}
+
+	// config values are checked against choices like command line values
+	if err := os.WriteFile(path, []byte(`{"level": "bogus", "tags": ["a", "z"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	type LogArgs struct {
+		Level string   `choices:"debug,info"`
+		Tags  []string `choices:"a,b"`
+	}
+	app = New(Options{ExitOnError: false, ConfigFile: path})
+	app.Add("log", func(a LogArgs) {})
+	if err := app.Run("log", "--level", "info"); err == nil || !strings.Contains(err.Error(), `invalid value "z" (choices: a, b)`) {
+		t.Fatalf("expected choices error for a config list, got %v", err)
+	}
+	if err := app.Run("log", "--tags", "a"); err == nil || !strings.Contains(err.Error(), `invalid value "bogus" (choices: debug, info)`) {
+		t.Fatalf("expected choices error for a config value, got %v", err)
+	}
+
+	// and against min and max
+	if err := os.WriteFile(path, []byte(`{"port": 70000, "weights": [1, 5]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	type RangeArgs struct {
+		Port    int   `max:"65535"`
+		Weights []int `max:"3"`
+	}
+	app = New(Options{ExitOnError: false, ConfigFile: path})
+	app.Add("serve", func(a RangeArgs) {})
+	if err := app.Run("serve", "--weights", "1"); err == nil || !strings.Contains(err.Error(), "--port must be at most 65535") {
+		t.Fatalf("expected range error for a config value, got %v", err)
+	}
+	if err := app.Run("serve", "--port", "80"); err == nil || !strings.Contains(err.Error(), "--weights must be at most 3") {
+		t.Fatalf("expected range error for a config list, got %v", err)
+	}
+}
+
+func TestConfigDecoder(t *testing.T) {
+	type DeployArgs struct {
+		Region   string `default:"us"`
+		Replicas int    `default:"3"`
+		Canary   *bool
+		Note     *string
+	}
+
+	path := filepath.Join(t.TempDir(), "deploy.yaml")
+	if err := os.WriteFile(path, []byte("region: eu\nreplicas: 0\ncanary: true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a minimal "key: value" decoder standing in for a YAML library
+	decoder := func(r io.Reader, v any) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		values := map[string]any{}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			key, val, _ := strings.Cut(line, ": ")
+			values[key] = val
+		}
+		*v.(*map[string]any) = values
+		return nil
+	}
+
+	app := New(Options{ExitOnError: false, ConfigFile: path, ConfigDecoder: decoder})
+	var got DeployArgs
+	app.Add("deploy", func(a DeployArgs) { got = a })
+	if err := app.Run("deploy"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Region != "eu" || got.Replicas != 0 || got.Canary == nil || !*got.Canary || got.Note != nil {
+		t.Fatalf("unexpected args: %+v", got)
+	}
+}
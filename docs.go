@@ -0,0 +1,311 @@
+package cliapp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Writes the command hierarchy as a Graphviz digraph.
+//
+// Every command and every intermediate name prefix (e.g. "db" for
+// "db migrate") is a node labeled with its last token, and edges connect
+// each node to its children. The root node is labeled with the program name.
+func (a *App) GenerateDot(w io.Writer) error {
+	nodes := map[string]bool{"": true}
+	for _, h := range a.cmds {
+		if h.alias != "" || h.hidden {
+			continue
+		}
+		for i := 1; i <= len(h.tokens); i++ {
+			nodes[strings.Join(h.tokens[:i], " ")] = true
+		}
+	}
+
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("digraph commands {\n")
+	for _, name := range names {
+		label := a.programName()
+		if name != "" {
+			label = name[strings.LastIndex(name, " ")+1:]
+		}
+		fmt.Fprintf(&b, "  %s [label=%s];\n", strconv.Quote(name), strconv.Quote(label))
+	}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		parent := ""
+		if i := strings.LastIndex(name, " "); i != -1 {
+			parent = name[:i]
+		}
+		fmt.Fprintf(&b, "  %s -> %s;\n", strconv.Quote(parent), strconv.Quote(name))
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes a JSON document describing every command, for IDE integrations and
+// documentation generators.
+//
+// Commands are sorted by name and described like the HelpCommand passed to
+// Options.HelpTemplate; the root command has an empty name. Alias and
+// hidden commands are left out.
+func (a *App) HelpJSON(w io.Writer) error {
+	doc := struct {
+		Program  string        `json:"program"`
+		Version  string        `json:"version,omitempty"`
+		Commands []HelpCommand `json:"commands"`
+	}{Program: a.programName(), Version: a.opts.Version, Commands: a.helpCommands()}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+	return e.Encode(doc)
+}
+
+// Describes the root command, if any, followed by every other command
+// sorted by name. Alias and hidden commands are left out.
+func (a *App) helpCommands() []HelpCommand {
+	cmds := []HelpCommand{}
+	if a.root != nil {
+		cmds = append(cmds, *a.helpData("", a.root).Command)
+	}
+	names := make([]string, 0, len(a.cmds))
+	for name, h := range a.cmds {
+		if h.alias == "" && !h.hidden {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		h := a.cmds[name]
+		cmds = append(cmds, *a.helpData(name, &h).Command)
+	}
+	return cmds
+}
+
+// Writes a man page for the application in roff format.
+//
+// The page has NAME, SYNOPSIS and DESCRIPTION sections taken from the
+// program name and the root command, an OPTIONS section with the root
+// command's arguments and options, and a COMMANDS section with a
+// subsection per command. Install it as `prog.1` in a man directory.
+func (a *App) GenerateManPage(w io.Writer) error {
+	prog := a.programName()
+	cmds := a.helpCommands()
+	var root *HelpCommand
+	if a.root != nil {
+		root, cmds = &cmds[0], cmds[1:]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, ".TH %s 1 \"\" %s\n", roffQuote(strings.ToUpper(prog)), roffQuote(strings.TrimSpace(prog+" "+a.opts.Version)))
+
+	b.WriteString(".SH NAME\n")
+	if root != nil && root.Help != "" {
+		fmt.Fprintf(&b, "%s \\- %s\n", roffEscape(prog), roffEscape(root.Help))
+	} else {
+		b.WriteString(roffEscape(prog) + "\n")
+	}
+
+	b.WriteString(".SH SYNOPSIS\n")
+	if root != nil {
+		fmt.Fprintf(&b, ".B %s\n", roffEscape(root.Usage))
+	}
+	if len(cmds) > 0 {
+		if root != nil {
+			b.WriteString(".br\n")
+		}
+		fmt.Fprintf(&b, ".B %s\n\\fIcommand\\fR [\\fIoptions\\fR]\n", roffEscape(prog))
+	}
+
+	if root != nil && root.Help != "" {
+		b.WriteString(".SH DESCRIPTION\n")
+		b.WriteString(roffEscape(root.Help) + "\n")
+	}
+
+	b.WriteString(".SH OPTIONS\n")
+	if flags := a.helpFlags(); len(flags) > 0 {
+		tags := make([]string, len(flags))
+		for i, flag := range flags {
+			tags[i] = "\\fB" + roffEscape(flag) + "\\fR"
+		}
+		writeManItem(&b, strings.Join(tags, ", "), "Show this help")
+	}
+	if a.hasVersion() {
+		writeManItem(&b, "\\fB\\-v\\fR, \\fB\\-\\-version\\fR", "Show version information")
+	}
+	if root != nil {
+		writeManCommand(&b, *root)
+	}
+
+	if len(cmds) > 0 {
+		b.WriteString(".SH COMMANDS\n")
+		for _, c := range cmds {
+			fmt.Fprintf(&b, ".SS %s\n", roffQuote(c.Name))
+			if c.Help != "" {
+				b.WriteString(roffEscape(c.Help) + "\n")
+			}
+			fmt.Fprintf(&b, ".PP\n.B %s\n", roffEscape(prog+" "+c.Usage))
+			writeManCommand(&b, c)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the arguments and options of a command as tagged paragraphs
+func writeManCommand(b *strings.Builder, c HelpCommand) {
+	for _, arg := range c.Args {
+		desc := arg.Type
+		if arg.Default != "" {
+			desc += " (default: " + arg.Default + ")"
+		}
+		writeManItem(b, "\\fI"+roffEscape(arg.Name)+"\\fR", desc)
+	}
+	for _, o := range c.Options {
+		if o.Deprecated != "" {
+			continue
+		}
+		tag := "\\fB" + roffEscape(o.Long) + "\\fR"
+		if o.Short != "" {
+			tag = "\\fB" + roffEscape(o.Short) + "\\fR, " + tag
+		}
+		if o.Type != "" {
+			tag += " \\fI" + roffEscape(o.Type) + "\\fR"
+		}
+		desc := o.Help
+		if o.Default != "" {
+			desc = strings.TrimSpace(desc + " (default: " + o.Default + ")")
+		}
+		if o.Required {
+			desc = strings.TrimSpace(desc + " (required)")
+		}
+		writeManItem(b, tag, desc)
+	}
+}
+
+// Writes a tagged paragraph. The tag is already escaped; desc is not.
+func writeManItem(b *strings.Builder, tag, desc string) {
+	fmt.Fprintf(b, ".TP\n%s\n", tag)
+	if desc != "" {
+		b.WriteString(roffEscape(desc) + "\n")
+	}
+}
+
+// Escapes text for use in a roff line: backslashes and dashes are written
+// as escapes, and a leading control character is neutralized.
+func roffEscape(s string) string {
+	s = strings.NewReplacer(`\`, `\e`, "-", `\-`).Replace(s)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+// Quotes s as a single roff macro argument
+func roffQuote(s string) string {
+	return `"` + strings.ReplaceAll(roffEscape(s), `"`, `""`) + `"`
+}
+
+// Writes Markdown documentation for the application.
+//
+// Each command gets a heading with its help text, usage line, and tables of
+// its arguments and options. Help text is escaped so that pipes and other
+// Markdown syntax are shown literally.
+func (a *App) GenerateMarkdown(w io.Writer) error {
+	prog := a.programName()
+	cmds := a.helpCommands()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", markdownEscape(prog))
+	if a.root != nil {
+		root := cmds[0]
+		cmds = cmds[1:]
+		if root.Help != "" {
+			fmt.Fprintf(&b, "\n%s\n", markdownEscape(root.Help))
+		}
+		writeMarkdownCommand(&b, root, "##")
+	}
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\n## %s %s\n", markdownEscape(prog), markdownEscape(c.Name))
+		if c.Help != "" {
+			fmt.Fprintf(&b, "\n%s\n", markdownEscape(c.Help))
+		}
+		c.Usage = prog + " " + c.Usage
+		writeMarkdownCommand(&b, c, "###")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Writes the usage line and the argument and option tables of a command,
+// using level for the table headings
+func writeMarkdownCommand(b *strings.Builder, c HelpCommand, level string) {
+	fmt.Fprintf(b, "\n```\n%s\n```\n", c.Usage)
+
+	if len(c.Args) > 0 {
+		fmt.Fprintf(b, "\n%s Arguments\n\n", level)
+		b.WriteString("| Position | Name | Type | Default |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		for _, arg := range c.Args {
+			fmt.Fprintf(b, "| %d | %s | %s | %s |\n", arg.Position, markdownEscape(arg.Name), markdownCode(arg.Type), markdownCode(arg.Default))
+		}
+	}
+
+	var opts []HelpOption
+	for _, o := range c.Options {
+		if o.Deprecated == "" {
+			opts = append(opts, o)
+		}
+	}
+	if len(opts) > 0 {
+		fmt.Fprintf(b, "\n%s Options\n\n", level)
+		b.WriteString("| Name | Type | Default | Description |\n")
+		b.WriteString("| --- | --- | --- | --- |\n")
+		for _, o := range opts {
+			name := markdownCode(o.Long)
+			if o.Short != "" {
+				name = markdownCode(o.Short) + ", " + name
+			}
+			desc := o.Help
+			if len(o.Choices) > 0 {
+				desc = strings.TrimSpace(desc + " (choices: " + strings.Join(o.Choices, ", ") + ")")
+			}
+			if o.Required {
+				desc = strings.TrimSpace(desc + " (required)")
+			}
+			fmt.Fprintf(b, "| %s | %s | %s | %s |\n", name, markdownCode(o.Type), markdownCode(o.Default), markdownEscape(desc))
+		}
+	}
+}
+
+// Escapes Markdown syntax, including table pipes, and joins lines
+func markdownEscape(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	return strings.NewReplacer(
+		`\`, `\\`, "`", "\\`", "|", `\|`, "*", `\*`, "_", `\_`,
+		"[", `\[`, "]", `\]`, "<", `\<`, ">", `\>`, "#", `\#`,
+	).Replace(s)
+}
+
+// Formats s as inline code for a table cell, or an empty string
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.ReplaceAll(s, "|", `\|`) + "`"
+}
@@ -0,0 +1,182 @@
+package cliapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGenerateDot(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	app.Add("status", func() {})
+	app.Add("db migrate", func() {})
+	app.Add("db seed", func() {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateDot(&buf); err != nil {
+		t.Fatalf("GenerateDot failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		"digraph commands {\n",
+		`"db" [label="db"];`,
+		`"db migrate" [label="migrate"];`,
+		`"" -> "status";`,
+		`"" -> "db";`,
+		`"db" -> "db migrate";`,
+		`"db" -> "db seed";`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+func TestHelpJSON(t *testing.T) {
+	type CopyArgs struct {
+		Src   string `arg:"0" help:"source"`
+		Dst   string `arg:"1" default:"."`
+		Force bool   `short:"-f" help:"overwrite"`
+		Mode  string `long:"--mode" default:"fast" required:"true"`
+		Token string `required:"true"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("", func() {})
+	app.Add("status", "Show status", func() {})
+	app.Add("copy", "Copy files", func(a CopyArgs) {})
+	app.Add("add", func(a, b int) {})
+	app.AddHidden("debug", func() {})
+
+	var buf bytes.Buffer
+	if err := app.HelpJSON(&buf); err != nil {
+		t.Fatalf("HelpJSON failed: %v", err)
+	}
+	var doc struct {
+		Commands []HelpCommand `json:"commands"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+
+	var names []string
+	for _, c := range doc.Commands {
+		names = append(names, c.Name)
+	}
+	if !slices.Equal(names, []string{"", "add", "copy", "status"}) {
+		t.Fatalf("unexpected commands: %q", names)
+	}
+
+	c := doc.Commands[2]
+	if c.Help != "Copy files" {
+		t.Fatalf("unexpected help: %q", c.Help)
+	}
+	wantArgs := []HelpArg{
+		{Position: 0, Name: "source", Type: "<string>"},
+		{Position: 1, Name: "dst", Type: "<string>", Default: ".", Optional: true},
+	}
+	if !reflect.DeepEqual(c.Args, wantArgs) {
+		t.Fatalf("unexpected args: %+v", c.Args)
+	}
+	wantOpts := []HelpOption{
+		{Long: "--force", Short: "-f", Negated: "--no-force", Help: "overwrite"},
+		{Long: "--mode", Type: "<string>", Default: "fast"},
+		{Long: "--token", Type: "<string>", Required: true},
+	}
+	if !reflect.DeepEqual(c.Options, wantOpts) {
+		t.Fatalf("unexpected options: %+v", c.Options)
+	}
+	if add := doc.Commands[1]; len(add.Args) != 2 || add.Args[1].Name != "arg1" || add.Args[1].Type != "<int>" {
+		t.Fatalf("unexpected args for add: %+v", add.Args)
+	}
+
+	// output is deterministic
+	var again bytes.Buffer
+	app.HelpJSON(&again)
+	if again.String() != buf.String() {
+		t.Fatalf("HelpJSON output differs between calls")
+	}
+}
+
+func TestGenerateManPage(t *testing.T) {
+	type CopyArgs struct {
+		Src   string `arg:"0" help:"source"`
+		Force bool   `short:"-f" help:"overwrite"`
+		Mode  string `default:"fast" help:".hidden-looking help"`
+	}
+
+	app := New(Options{ExitOnError: false, Version: "1.0"})
+	app.SetName("mytool")
+	app.Add("", "A tool for files", func() {})
+	app.Add("copy", "Copy files", func(a CopyArgs) {})
+	app.Add("status", func() {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateManPage(&buf); err != nil {
+		t.Fatalf("GenerateManPage failed: %v", err)
+	}
+	out := buf.String()
+
+	for _, want := range []string{
+		".TH \"MYTOOL\" 1 \"\" \"mytool 1.0\"\n",
+		".SH NAME\nmytool \\- A tool for files\n",
+		".SH SYNOPSIS\n",
+		".SH DESCRIPTION\n",
+		".SH OPTIONS\n.TP\n\\fB\\-h\\fR, \\fB\\-\\-help\\fR\nShow this help\n",
+		".SH COMMANDS\n",
+		".SS \"copy\"\nCopy files\n.PP\n.B mytool copy <src> [\\-\\-force] [\\-\\-mode <string>]\n",
+		".TP\n\\fIsource\\fR\n<string>\n",
+		".TP\n\\fB\\-f\\fR, \\fB\\-\\-force\\fR\noverwrite\n",
+		".TP\n\\fB\\-\\-mode\\fR \\fI<string>\\fR\n\\&.hidden\\-looking help (default: fast)\n",
+		".SS \"status\"\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in man page:\n%s", want, out)
+		}
+	}
+}
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestGenerateMarkdown(t *testing.T) {
+	type CopyArgs struct {
+		Src   string `arg:"0" help:"source file"`
+		Dst   string `arg:"1" default:"."`
+		Force bool   `short:"-f" help:"overwrite *all* files"`
+		Mode  string `default:"fast" choices:"fast,safe" help:"copy mode: fast|safe"`
+		Token string `required:"true" help:"API token"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.SetName("mytool")
+	app.Add("", "A tool for files", func() {})
+	app.Add("copy", "Copy files", func(a CopyArgs) {})
+	app.Add("add", "Add two numbers", func(a, b int) {})
+	app.Add("status", func() {})
+
+	var buf bytes.Buffer
+	if err := app.GenerateMarkdown(&buf); err != nil {
+		t.Fatalf("GenerateMarkdown failed: %v", err)
+	}
+
+	golden := filepath.Join("testdata", "markdown.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != string(want) {
+		t.Fatalf("output differs from %s (run with -update to refresh):\n%s", golden, buf.String())
+	}
+}
@@ -0,0 +1,262 @@
+package cliapp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Selects how errors are written to LogError.
+type ErrorFormat int
+
+const (
+	// plain error message (default)
+	ErrorFormatText ErrorFormat = iota
+	// one JSON object per error with kind, command, option, position and message
+	ErrorFormatJSON
+)
+
+// Error kinds reported in structured error output
+const (
+	kindUnknownCommand  = "unknown-command"
+	kindUnknownOption   = "unknown-option"
+	kindMissingValue    = "missing-value"
+	kindMissingRequired = "missing-required"
+	kindWrongArgCount   = "wrong-arg-count"
+	kindInvalidValue    = "invalid-value"
+	kindValidation      = "validation"
+	kindCommand         = "command"
+)
+
+// Errors matched with errors.Is by the usage errors Run returns
+var (
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrUnknownOption  = errors.New("unknown option")
+	ErrMissingValue   = errors.New("missing value")
+	ErrWrongArgCount  = errors.New("wrong number of arguments")
+	ErrInvalidValue   = errors.New("invalid value")
+)
+
+// Returned by App.Parse for args that request help or version output,
+// which Run prints before returning nil
+var ErrHelp = errors.New("help requested")
+
+// Returned by Run for a command name that matches no registered command.
+// Matches ErrUnknownCommand.
+type UnknownCommandError struct {
+	Name       string
+	Suggestion string // closest registered command, if any
+}
+
+func (e *UnknownCommandError) Error() string {
+	msg := "unknown command: " + e.Name
+	if e.Suggestion != "" {
+		msg += fmt.Sprintf(". Did you mean '%s'?", e.Suggestion)
+	}
+	return msg
+}
+
+func (e *UnknownCommandError) Is(target error) bool { return target == ErrUnknownCommand }
+
+// Returned by Run for an option the command does not accept. Matches
+// ErrUnknownOption.
+type UnknownOptionError struct {
+	Option  string
+	Cluster string // the short option cluster (e.g. -xvf) the option was part of, if any
+}
+
+func (e *UnknownOptionError) Error() string {
+	if e.Cluster != "" {
+		return fmt.Sprintf("unknown option: %s in %s", e.Option, e.Cluster)
+	}
+	return "unknown option: " + e.Option
+}
+
+func (e *UnknownOptionError) Is(target error) bool { return target == ErrUnknownOption }
+
+// Returned by Run for an option given without its value. Matches
+// ErrMissingValue.
+type MissingValueError struct {
+	Option string
+}
+
+func (e *MissingValueError) Error() string { return "missing value for " + e.Option }
+
+func (e *MissingValueError) Is(target error) bool { return target == ErrMissingValue }
+
+// Returned by Run for a value that cannot be converted to the type of its
+// argument or option, or that fails its `choices`, `min` or `max` tag.
+// Matches ErrInvalidValue.
+type ParseError struct {
+	Command  string       // matched command; "(root)" for the root command
+	Position int          // 0-based argument position, or -1 for an option
+	Option   string       // option name as given, empty for positional args
+	Value    string       // raw value
+	Type     reflect.Type // type the value was parsed into
+	Err      error        // underlying error, e.g. from strconv
+
+	msg string
+}
+
+func (e *ParseError) Error() string { return e.msg }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+func (e *ParseError) Is(target error) bool { return target == ErrInvalidValue }
+
+func newParseError(command string, position int, option, value string, t reflect.Type, err error, format string, args ...any) *ParseError {
+	return &ParseError{Command: command, Position: position, Option: option, Value: value, Type: t, Err: err, msg: fmt.Sprintf(format, args...)}
+}
+
+// An error that carries the process exit code to use for it.
+//
+// When a handler returns an ExitCoder, Run returns it unchanged and, with
+// ExitOnError enabled, exits with its code instead of 1.
+type ExitCoder interface {
+	error
+	ExitCode() int
+}
+
+type exitError struct {
+	code int
+	msg  string
+}
+
+func (e *exitError) Error() string { return e.msg }
+
+func (e *exitError) ExitCode() int { return e.code }
+
+// Returns an error that makes Run exit with code when ExitOnError is set.
+// An empty msg exits without printing anything.
+func Exit(code int, msg string) error {
+	return &exitError{code: code, msg: msg}
+}
+
+// Returns the exit code for err: the code of the first ExitCoder in its
+// chain, or 1.
+func exitCode(err error) int {
+	var ec ExitCoder
+	if errors.As(err, &ec) {
+		return ec.ExitCode()
+	}
+	return 1
+}
+
+// A panic recovered from a handler when RecoverPanics is set.
+type PanicError struct {
+	// value passed to panic
+	Value any
+	// stack trace of the panicking goroutine
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v\n\n%s", e.Value, e.Stack)
+}
+
+// Returns the panic value when it is an error
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// Carries the context an error occurred in, for structured error output.
+// The message is that of the wrapped error.
+type contextError struct {
+	kind     string
+	command  string
+	option   string
+	position int // -1 when the error is not about a positional argument
+	err      error
+}
+
+func (e *contextError) Error() string { return e.err.Error() }
+
+func (e *contextError) Unwrap() error { return e.err }
+
+// Matches the sentinel error of the error's kind, so that e.g. every
+// wrong-arg-count error matches ErrWrongArgCount
+func (e *contextError) Is(target error) bool {
+	switch e.kind {
+	case kindUnknownCommand:
+		return target == ErrUnknownCommand
+	case kindUnknownOption:
+		return target == ErrUnknownOption
+	case kindMissingValue:
+		return target == ErrMissingValue
+	case kindWrongArgCount:
+		return target == ErrWrongArgCount
+	case kindInvalidValue:
+		return target == ErrInvalidValue
+	}
+	return false
+}
+
+// Wraps err with the command it occurred in
+func inCommand(command, kind string, err error) error {
+	return &contextError{kind: kind, command: command, position: -1, err: err}
+}
+
+// Wraps err with the option it is about
+func atOption(kind, option string, err error) error {
+	return &contextError{kind: kind, option: option, position: -1, err: err}
+}
+
+// Wraps err with the 0-based position of the argument it is about
+func atPosition(kind string, position int, err error) error {
+	return &contextError{kind: kind, position: position, err: err}
+}
+
+// Structured form of an error, written when ErrorFormat is ErrorFormatJSON
+type diagnostic struct {
+	Kind     string `json:"kind"`
+	Command  string `json:"command,omitempty"`
+	Option   string `json:"option,omitempty"`
+	Position *int   `json:"position,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Collects the context attached anywhere in err's chain.
+// Inner (more specific) context wins over outer context.
+func newDiagnostic(err error) diagnostic {
+	d := diagnostic{Kind: kindCommand, Message: err.Error()}
+	for e := err; e != nil; e = errors.Unwrap(e) {
+		ce, ok := e.(*contextError)
+		if !ok {
+			continue
+		}
+		if ce.kind != "" {
+			d.Kind = ce.kind
+		}
+		if ce.command != "" {
+			d.Command = ce.command
+		}
+		if ce.option != "" {
+			d.Option = ce.option
+		}
+		if ce.position >= 0 {
+			pos := ce.position
+			d.Position = &pos
+		}
+	}
+	return d
+}
+
+// Writes err to w in the given format. Text errors are shown in red when
+// color is true.
+func writeError(w io.Writer, format ErrorFormat, err error, color bool) {
+	if err.Error() == "" {
+		return
+	}
+	if format == ErrorFormatJSON {
+		json.NewEncoder(w).Encode(newDiagnostic(err))
+		return
+	}
+	if color {
+		io.WriteString(w, ansiRed+err.Error()+ansiReset+"\n")
+		return
+	}
+	io.WriteString(w, err.Error()+"\n")
+}
@@ -0,0 +1,214 @@
+package cliapp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONErrorFormat(t *testing.T) {
+	type CreateArgs struct {
+		Count int `long:"--count"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("create", func(a CreateArgs) {})
+
+	err := app.Run("create", "--count", "many")
+	if err == nil {
+		t.Fatalf("expected parse error")
+	}
+
+	var buf bytes.Buffer
+	writeError(&buf, ErrorFormatJSON, err, false)
+
+	var d struct {
+		Kind     string `json:"kind"`
+		Command  string `json:"command"`
+		Option   string `json:"option"`
+		Position *int   `json:"position"`
+		Message  string `json:"message"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &d); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if d.Kind != kindInvalidValue || d.Command != "create" || d.Option != "--count" || d.Position != nil {
+		t.Fatalf("unexpected diagnostic: %+v", d)
+	}
+	if d.Message != err.Error() {
+		t.Fatalf("expected message %q, got %q", err.Error(), d.Message)
+	}
+}
+
+func TestTextErrorFormat(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	err := app.Run("missing")
+	if err == nil {
+		t.Fatalf("expected unknown command error")
+	}
+
+	var buf bytes.Buffer
+	writeError(&buf, ErrorFormatText, err, false)
+	if buf.String() != "unknown command: missing\n" {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+}
+
+func TestExitCoder(t *testing.T) {
+	app := New(Options{ExitOnError: false})
+	app.Add("fail", func() error {
+		return Exit(3, "deploy failed")
+	})
+	app.Add("plain", func() error {
+		return errors.New("boom")
+	})
+
+	err := app.Run("fail")
+	var ec ExitCoder
+	if !errors.As(err, &ec) {
+		t.Fatalf("expected an ExitCoder, got %v", err)
+	}
+	if ec.ExitCode() != 3 || err.Error() != "deploy failed" {
+		t.Fatalf("unexpected exit error: code=%d msg=%q", ec.ExitCode(), err.Error())
+	}
+	if got := exitCode(fmt.Errorf("wrapped: %w", err)); got != 3 {
+		t.Fatalf("expected wrapped exit code 3, got %d", got)
+	}
+	if got := exitCode(app.Run("plain")); got != 1 {
+		t.Fatalf("expected default exit code 1, got %d", got)
+	}
+
+	var buf bytes.Buffer
+	writeError(&buf, ErrorFormatText, Exit(0, ""), false)
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output for an empty message, got %q", buf.String())
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	app := New(Options{ExitOnError: false, RecoverPanics: true})
+	app.Add("boom", func() { panic("kaboom") })
+	sentinel := errors.New("sentinel")
+	app.Add("fail", func() { panic(sentinel) })
+
+	err := app.Run("boom")
+	var pe *PanicError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a *PanicError, got %v", err)
+	}
+	if pe.Value != "kaboom" || !strings.HasPrefix(err.Error(), "panic: kaboom") || len(pe.Stack) == 0 {
+		t.Fatalf("unexpected panic error: %v", err)
+	}
+
+	if err := app.Run("fail"); !errors.Is(err, sentinel) {
+		t.Fatalf("expected the panic value to be wrapped, got %v", err)
+	}
+}
+
+func TestExitFunc(t *testing.T) {
+	var stderr bytes.Buffer
+	var codes []int
+	app := New(Options{ExitOnError: true, LogError: &stderr, Exit: func(code int) {
+		codes = append(codes, code)
+	}})
+	app.Add("fail", func() error { return Exit(4, "failed") })
+	app.Add("ok", func() {})
+
+	if err := app.Run("ok"); err != nil || len(codes) != 0 {
+		t.Fatalf("unexpected exit: codes=%v err=%v", codes, err)
+	}
+	if err := app.Run("fail"); err == nil {
+		t.Fatalf("expected the error to be returned once Exit returns")
+	}
+	if err := app.Run("nope"); err == nil {
+		t.Fatalf("expected unknown command error")
+	}
+	if len(codes) != 2 || codes[0] != 4 || codes[1] != 1 {
+		t.Fatalf("unexpected exit codes: %v", codes)
+	}
+	if !strings.HasPrefix(stderr.String(), "failed\nunknown command: nope") {
+		t.Fatalf("unexpected error output: %q", stderr.String())
+	}
+}
+
+func TestUsageErrorTypes(t *testing.T) {
+	type BuildArgs struct {
+		Out     string `short:"-o"`
+		Verbose bool   `short:"-v"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("build", func(a BuildArgs) {})
+	app.Add("add", func(a, b int) {})
+
+	err := app.Run("biuld")
+	var uc *UnknownCommandError
+	if !errors.Is(err, ErrUnknownCommand) || !errors.As(err, &uc) || uc.Name != "biuld" || uc.Suggestion != "build" {
+		t.Fatalf("expected UnknownCommandError for biuld, got %#v", err)
+	}
+
+	err = app.Run("build", "--color")
+	var uo *UnknownOptionError
+	if !errors.Is(err, ErrUnknownOption) || !errors.As(err, &uo) || uo.Option != "--color" {
+		t.Fatalf("expected UnknownOptionError for --color, got %v", err)
+	}
+	err = app.Run("build", "-vx")
+	if !errors.As(err, &uo) || uo.Option != "-x" || uo.Cluster != "-vx" {
+		t.Fatalf("expected UnknownOptionError for -x in -vx, got %v", err)
+	}
+
+	err = app.Run("build", "--out")
+	var mv *MissingValueError
+	if !errors.Is(err, ErrMissingValue) || !errors.As(err, &mv) || mv.Option != "--out" {
+		t.Fatalf("expected MissingValueError for --out, got %v", err)
+	}
+
+	err = app.Run("add", "1")
+	if !errors.Is(err, ErrWrongArgCount) || errors.Is(err, ErrUnknownOption) {
+		t.Fatalf("expected ErrWrongArgCount, got %v", err)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	type ServeArgs struct {
+		Host string `arg:"0"`
+		Port int    `arg:"1"`
+		TTL  time.Duration
+		Mode string `short:"-m" choices:"fast,safe"`
+	}
+
+	app := New(Options{ExitOnError: false})
+	app.Add("serve", func(a ServeArgs) {})
+	app.Add("add", func(a, b int) {})
+	app.Add("sum", func(nums ...float64) {})
+
+	for _, tc := range []struct {
+		args []string
+		want ParseError
+	}{
+		{[]string{"add", "1", "x"}, ParseError{Command: "add", Position: 1, Value: "x", Type: reflect.TypeOf(0)}},
+		{[]string{"sum", "1", "2", "y"}, ParseError{Command: "sum", Position: 0, Value: "y", Type: reflect.TypeOf(0.0)}},
+		{[]string{"serve", "localhost", "http"}, ParseError{Command: "serve", Position: 1, Value: "http", Type: reflect.TypeOf(0)}},
+		{[]string{"serve", "localhost", "80", "--ttl", "soon"}, ParseError{Command: "serve", Position: -1, Option: "--ttl", Value: "soon", Type: reflect.TypeOf(time.Second)}},
+		{[]string{"serve", "localhost", "80", "-mslow"}, ParseError{Command: "serve", Position: -1, Option: "-m", Value: "slow", Type: reflect.TypeOf("")}},
+	} {
+		err := app.Run(tc.args...)
+		var pe *ParseError
+		if !errors.As(err, &pe) || !errors.Is(err, ErrInvalidValue) {
+			t.Fatalf("Run(%q): expected ParseError, got %v", tc.args, err)
+		}
+		got := *pe
+		got.Err, got.msg = nil, ""
+		if got != tc.want {
+			t.Fatalf("Run(%q): got %+v, want %+v", tc.args, got, tc.want)
+		}
+		if pe.Err == nil || !strings.Contains(err.Error(), pe.Error()) {
+			t.Fatalf("Run(%q): unexpected message %q for %q", tc.args, err.Error(), pe.Error())
+		}
+	}
+}
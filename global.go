@@ -0,0 +1,181 @@
+package cliapp
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Registers a struct of options accepted by every command, such as
+// --verbose or --config. ptr must point to a struct; it is filled on every
+// Run before the command's handler is called, so handlers read the global
+// options through it.
+//
+// Global options are taken from anywhere before a -- argument, before or
+// after the command name, and removed from the args the command parses.
+// Fields are tagged like those of command structs, except that positional
+// fields are not allowed. A command option may not share a name with a
+// global option.
+func (a *App) GlobalFlags(ptr any) {
+	v := reflect.ValueOf(ptr)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		panic("GlobalFlags requires a non-nil pointer to a struct")
+	}
+	st := v.Elem().Type()
+	_, longMap, _ := a.buildFieldMaps(st)
+	for _, f := range structFields(st) {
+		if !isOptionField(f) {
+			panic(fmt.Sprintf("global flags field %s must be an option", f.Name))
+		}
+		if !isFieldType(f.Type) {
+			panic(fmt.Sprintf("unsupported type %s of global flags field %s", f.Type, f.Name))
+		}
+		if _, ok := f.Tag.Lookup("toggle"); ok && f.Type.Kind() != reflect.Bool {
+			panic(fmt.Sprintf("toggle field %s of global flags must be bool", f.Name))
+		}
+		if other, ok := f.Tag.Lookup("only-with"); ok {
+			if _, ok := longMap[other]; !ok {
+				panic(fmt.Sprintf("only-with on global flags field %s refers to unknown option %s", f.Name, other))
+			}
+		}
+	}
+	if err := a.checkOptionNames(st); err != nil {
+		panic(fmt.Sprintf("global flags: %v", err))
+	}
+
+	a.globals = v
+	for name, h := range a.cmds {
+		a.checkGlobalConflicts(name, h.targs)
+	}
+	if a.root != nil {
+		a.checkGlobalConflicts("", a.root.targs)
+	}
+}
+
+// Panics if a struct parameter of a command declares an option that is
+// also a global option
+func (a *App) checkGlobalConflicts(name string, targs []reflect.Type) {
+	if !a.globals.IsValid() {
+		return
+	}
+	globals := a.laterOptions([]reflect.Type{a.globals.Type().Elem()})
+	for i, t := range targs {
+		st, ok := structParam(t)
+		if !ok {
+			continue
+		}
+		for _, f := range structFields(st) {
+			for _, opt := range a.allOptionNames(f) {
+				if _, ok := globals[opt]; ok {
+					panic(fmt.Sprintf("option %s of parameter %d for command %q is already a global option", opt, i, name))
+				}
+			}
+		}
+	}
+}
+
+// Removes the global options from args and parses them into a new value of
+// the struct registered with GlobalFlags, which is invalid when there is
+// none. Options are recognized the way an earlier struct parameter
+// recognizes those of a later one, up to a -- argument; the value of an
+// option of the command is left to the command. The remaining args are
+// returned even when parsing fails.
+func (a *App) parseGlobals(args []string) ([]string, reflect.Value, error) {
+	if !a.globals.IsValid() {
+		return args, reflect.Value{}, nil
+	}
+	st := a.globals.Type().Elem()
+	globals := a.laterOptions([]reflect.Type{st})
+	var own, rest []string
+	for i := 0; i < len(args); {
+		if args[i] == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if n := globals.span(args[i:]); n > 0 {
+			own = append(own, args[i:i+n]...)
+			i += n
+			continue
+		}
+		// the command is named by the args kept so far
+		if a.commandOptions(rest)[args[i]] && i+1 < len(args) {
+			rest = append(rest, args[i], args[i+1])
+			i += 2
+			continue
+		}
+		rest = append(rest, args[i])
+		i++
+	}
+
+	sv, _, _, err := a.parseStructArgs(own, st, nil)
+	if err != nil {
+		return rest, reflect.Value{}, inCommand("", "", fmt.Errorf("failed to parse global options: %w", err))
+	}
+	return rest, sv, nil
+}
+
+// Returns the options of the command named by args, or of the root command
+// when args name none, mapped to whether they take a value
+func (a *App) commandOptions(args []string) laterOptions {
+	if _, h, n := a.match(args); n > 0 {
+		return a.laterOptions(h.targs)
+	}
+	if a.root != nil {
+		return a.laterOptions(a.root.targs)
+	}
+	return nil
+}
+
+// Reports whether args ask for help or version output rather than running
+// a command, so that invalid or missing global options do not prevent it
+func (a *App) requestsHelp(args []string) bool {
+	if len(args) == 0 {
+		b := a.noArgsBehavior()
+		return b == NoArgsShowHelp || (b == NoArgsRunRoot && a.root == nil)
+	}
+	if a.isHelpFlag(args[0]) || a.isHelpCommand(args) || a.isVersionRequest(args[0]) {
+		return true
+	}
+	_, _, n := a.match(args)
+	if g := a.groupLen(args); g > n {
+		return g == len(args) || a.isHelpFlag(args[g])
+	}
+	return n > 0 && n < len(args) && a.isHelpFlag(args[n])
+}
+
+// Returns the number of leading args that are global options and their
+// values. A trailing option still waiting for its value is not counted.
+func (a *App) leadingGlobals(args []string) int {
+	if !a.globals.IsValid() {
+		return 0
+	}
+	globals := a.laterOptions([]reflect.Type{a.globals.Type().Elem()})
+	i := 0
+	for i < len(args) {
+		n := globals.span(args[i:])
+		if n == 0 || (globals[args[i]] && n == 1) {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
+// Prints the global options section of the help, if there are any
+func (a *App) printGlobalOptions(w io.Writer) {
+	if !a.globals.IsValid() {
+		return
+	}
+	var rows []helpRow
+	for _, o := range a.structOptions(a.globals.Type().Elem()) {
+		if !o.hidden {
+			rows = append(rows, a.optionRow(o))
+		}
+	}
+	if len(rows) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	a.printHeading(w, "Global Options:")
+	a.printRows(w, rows)
+}
@@ -0,0 +1,234 @@
+package cliapp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGlobalFlags(t *testing.T) {
+	type Globals struct {
+		Verbose bool   `short:"v" help:"verbose output"`
+		Config  string `default:"app.json"`
+	}
+	type BuildArgs struct {
+		Target string `arg:"0"`
+		Race   bool
+	}
+
+	var globals Globals
+	var got BuildArgs
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.GlobalFlags(&globals)
+	app.Add("build", func(a BuildArgs) { got = a })
+
+	for _, args := range [][]string{
+		{"-v", "--config", "ci.json", "build", "./cmd", "--race"},
+		{"build", "./cmd", "--race", "--verbose", "--config=ci.json"},
+		{"--config", "ci.json", "build", "./cmd", "-v", "--race"},
+	} {
+		globals, got = Globals{}, BuildArgs{}
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+		if !globals.Verbose || globals.Config != "ci.json" || got.Target != "./cmd" || !got.Race {
+			t.Fatalf("Run(%q): unexpected args: %+v %+v", args, globals, got)
+		}
+	}
+
+	// defaults apply on every run, and args after -- are left to the command
+	if err := app.Run("build", "--", "-v"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if globals.Verbose || globals.Config != "app.json" || got.Target != "-v" {
+		t.Fatalf("unexpected args: %+v %+v", globals, got)
+	}
+
+	if err := app.Run("build", "--config"); err == nil || !strings.Contains(err.Error(), "failed to parse global options") {
+		t.Fatalf("expected missing value error, got %v", err)
+	}
+
+	if err := app.Run("build", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "Global Options:\n  -v|--verbose|--no-verbose    verbose output") {
+		t.Fatalf("help missing global options:\n%s", buf.String())
+	}
+}
+
+func TestRequiredGlobalsAndHelp(t *testing.T) {
+	type Globals struct {
+		Token string `long:"--token" required:"true"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.GlobalFlags(&Globals{})
+	app.Add("build", "Build it", func() {})
+	app.Add("remote add", "Add a remote", func(name string) {})
+
+	// help is shown without the required global option
+	for _, args := range [][]string{nil, {"--help"}, {"help"}, {"help", "build"}, {"build", "-h"}, {"remote"}, {"remote", "add", "--help"}} {
+		buf.Reset()
+		if err := app.Run(append([]string{}, args...)...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+		if buf.Len() == 0 {
+			t.Fatalf("Run(%q): expected help output", args)
+		}
+	}
+
+	if err := app.Run("build"); err == nil || !strings.Contains(err.Error(), "missing required option: --token") {
+		t.Fatalf("expected missing global option error, got %v", err)
+	}
+}
+
+func TestGlobalsLeaveCommandOptionValues(t *testing.T) {
+	type Globals struct {
+		Verbose bool `short:"v"`
+	}
+	type CreateArgs struct {
+		In  string `arg:"0"`
+		Out string
+	}
+
+	var globals Globals
+	var got CreateArgs
+	app := New(Options{ExitOnError: false})
+	app.GlobalFlags(&globals)
+	app.Add("create", func(a CreateArgs) { got = a })
+
+	if err := app.Run("create", "in", "--out", "-v"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.In != "in" || got.Out != "-v" || globals.Verbose {
+		t.Fatalf("unexpected args: %+v %+v", got, globals)
+	}
+
+	if err := app.Run("-v", "create", "in", "--out=x", "-v"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got.Out != "x" || !globals.Verbose {
+		t.Fatalf("unexpected args: %+v %+v", got, globals)
+	}
+}
+
+func TestGlobalFlagsConflict(t *testing.T) {
+	type Globals struct {
+		Verbose bool
+	}
+	type RunArgs struct {
+		Verbose bool
+	}
+
+	for _, register := range []func(app *App){
+		func(app *App) {
+			app.GlobalFlags(&Globals{})
+			app.Add("run", func(a RunArgs) {})
+		},
+		func(app *App) {
+			app.Add("run", func(a RunArgs) {})
+			app.GlobalFlags(&Globals{})
+		},
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if r == nil || !strings.Contains(fmt.Sprint(r), `option --verbose of parameter 0 for command "run" is already a global option`) {
+					t.Fatalf("expected panic for conflicting global option, got %v", r)
+				}
+			}()
+			register(New(Options{ExitOnError: false}))
+		}()
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic for positional global field")
+		}
+	}()
+	New(Options{ExitOnError: false}).GlobalFlags(&struct {
+		File string `arg:"0"`
+	}{})
+}
+
+func TestLeadingOptions(t *testing.T) {
+	type Globals struct {
+		Verbose bool   `short:"v"`
+		Config  string `short:"c"`
+	}
+
+	var globals Globals
+	var created string
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+	app.GlobalFlags(&globals)
+	app.Add("create", "Create an item", func(name string) { created = name })
+	app.Add("remote add", "Add a remote", func(name string) {})
+
+	if err := app.Run("--verbose", "-c", "ci.json", "create", "x"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !globals.Verbose || globals.Config != "ci.json" || created != "x" {
+		t.Fatalf("unexpected args: %+v %q", globals, created)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-v", "-h", "create"}, "Create an item"},
+		{[]string{"--help", "remote", "add"}, "Add a remote"},
+	} {
+		buf.Reset()
+		if err := app.Run(tc.args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", tc.args, err)
+		}
+		if !strings.Contains(buf.String(), tc.want) || strings.Contains(buf.String(), "Commands:") {
+			t.Fatalf("Run(%q): expected command help, got:\n%s", tc.args, buf.String())
+		}
+	}
+
+	var uo *UnknownOptionError
+	if err := app.Run("--bogus", "create", "x"); !errors.As(err, &uo) || uo.Option != "--bogus" {
+		t.Fatalf("expected unknown option error, got %v", err)
+	}
+
+	buf.Reset()
+	if err := app.Run("__complete", "-v", "--config", "ci.json", "cr"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if buf.String() != "create\n" {
+		t.Fatalf("unexpected completions %q", buf.String())
+	}
+}
+
+func TestParseLeavesGlobalsUnchanged(t *testing.T) {
+	type Globals struct {
+		Verbose bool   `short:"v"`
+		Config  string `default:"app.json"`
+	}
+
+	globals := Globals{Config: "keep.json"}
+	app := New(Options{ExitOnError: false})
+	app.GlobalFlags(&globals)
+	app.Add("build", func(target string) {})
+
+	name, values, err := app.Parse("-v", "--config", "ci.json", "build", "./cmd")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if name != "build" || len(values) != 1 || values[0] != "./cmd" {
+		t.Fatalf("unexpected result: %q %v", name, values)
+	}
+	if globals != (Globals{Config: "keep.json"}) {
+		t.Fatalf("Parse modified the globals: %+v", globals)
+	}
+
+	if _, _, err := app.Parse("build", "./cmd", "--config"); err == nil {
+		t.Fatalf("expected an error for a global option without a value")
+	}
+}
@@ -0,0 +1,151 @@
+package cliapp
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A set of commands registered under a common name prefix.
+//
+// Commands added to a group are stored in the App like any other command
+// whose name contains spaces, so `app.Group("remote").Add("add", ...)` is
+// equivalent to `app.Add("remote add", ...)`.
+type Group struct {
+	app    *App
+	tokens []string
+}
+
+// Returns a group whose commands are registered under name.
+func (a *App) Group(name string) *Group {
+	return &Group{app: a, tokens: strings.Fields(name)}
+}
+
+// Returns a nested group whose commands are registered under this group's
+// name followed by name.
+func (g *Group) Group(name string) *Group {
+	return &Group{app: g.app, tokens: g.join(name)}
+}
+
+// Registers a command under the group. Accepts the same arguments as App.Add.
+func (g *Group) Add(name string, rest ...any) {
+	g.app.AddTokens(g.join(name), rest...)
+}
+
+func (g *Group) join(name string) []string {
+	return append(slices.Clone(g.tokens), strings.Fields(name)...)
+}
+
+// A node in the command hierarchy. Intermediate name prefixes that have no
+// command of their own have a nil handler.
+type commandNode struct {
+	tokens []string
+	h      *handler
+}
+
+// Returns every command and intermediate name prefix below prefix, ordered
+// so that each node is directly followed by its children.
+func (a *App) commandTree(prefix []string) []commandNode {
+	nodes := map[string]*commandNode{}
+	for _, h := range a.cmds {
+		if h.alias != "" || h.hidden {
+			continue
+		}
+		if len(h.tokens) <= len(prefix) || !a.hasTokenPrefix(h.tokens, prefix) {
+			continue
+		}
+		for i := len(prefix) + 1; i <= len(h.tokens); i++ {
+			key := strings.Join(h.tokens[:i], " ")
+			if _, ok := nodes[key]; !ok {
+				nodes[key] = &commandNode{tokens: h.tokens[:i]}
+			}
+		}
+		nodes[strings.Join(h.tokens, " ")].h = &h
+	}
+
+	tree := make([]commandNode, 0, len(nodes))
+	for _, n := range nodes {
+		tree = append(tree, *n)
+	}
+	slices.SortFunc(tree, func(x, y commandNode) int {
+		return slices.Compare(x.tokens, y.tokens)
+	})
+	return tree
+}
+
+// Returns the number of leading args that name a group, i.e. a strict
+// prefix of some registered command.
+func (a *App) groupLen(args []string) int {
+	best := 0
+	for _, h := range a.cmds {
+		n := 0
+		for n < len(h.tokens)-1 && n < len(args) && a.tokenEqual(h.tokens[n], args[n]) {
+			n++
+		}
+		if n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+// Reports whether a command name token matches an argument, ignoring case
+// when CaseInsensitive is set.
+func (a *App) tokenEqual(tok, arg string) bool {
+	if a.opts.CaseInsensitive {
+		return strings.EqualFold(tok, arg)
+	}
+	return tok == arg
+}
+
+// Reports whether args starts with the command name tokens
+func (a *App) hasTokenPrefix(args, tokens []string) bool {
+	if len(tokens) > len(args) {
+		return false
+	}
+	for i, tok := range tokens {
+		if !a.tokenEqual(tok, args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Prints the commands below prefix, indenting each level of the hierarchy.
+func (a *App) printCommandList(prefix []string) {
+	tree := a.commandTree(prefix)
+	label := func(n commandNode) string {
+		depth := len(n.tokens) - len(prefix) - 1
+		s := strings.Repeat("  ", depth) + n.tokens[len(n.tokens)-1]
+		if aliases := a.aliasesOf(strings.Join(n.tokens, " ")); n.h != nil && len(aliases) > 0 {
+			s += " (alias: " + strings.Join(aliases, ", ") + ")"
+		}
+		return s
+	}
+
+	max := 0
+	for _, n := range tree {
+		if l := len(label(n)); l > max {
+			max = l
+		}
+	}
+	for _, n := range tree {
+		l := label(n)
+		if n.h != nil && n.h.help != "" {
+			fmt.Fprintf(a.opts.Log, "  %s%s  %s\n", a.paint(a.opts.Log, ansiCyan, l), strings.Repeat(" ", max-len(l)), n.h.help)
+		} else {
+			fmt.Fprintf(a.opts.Log, "  %s\n", a.paint(a.opts.Log, ansiCyan, l))
+		}
+	}
+}
+
+// Prints the subcommands of an incomplete command path such as "remote".
+func (a *App) printGroupHelp(tokens []string) {
+	a.printHeading(a.opts.Log, "Usage:")
+	fmt.Fprintf(a.opts.Log, "  %s <command> [options...]\n", strings.Join(tokens, " "))
+	fmt.Fprintln(a.opts.Log)
+	a.printHeading(a.opts.Log, "Commands:")
+	a.printCommandList(tokens)
+	fmt.Fprintln(a.opts.Log)
+	a.printCommonOptions(a.opts.Log, false)
+}
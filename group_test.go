@@ -0,0 +1,92 @@
+package cliapp
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf})
+
+	var got string
+	remote := app.Group("remote")
+	remote.Add("add", "Add a remote", func(name string) { got = "add " + name })
+	remote.Add("remove", func(name string) { got = "remove " + name })
+	remote.Group("branch").Add("set", func() { got = "set" })
+	app.Add("status", "Show status", func() { got = "status" })
+
+	if err := app.Run("remote", "add", "origin"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "add origin" {
+		t.Fatalf("unexpected command: %q", got)
+	}
+	if err := app.Run("remote", "branch", "set"); err != nil || got != "set" {
+		t.Fatalf("nested group: got=%q err=%v", got, err)
+	}
+
+	buf.Reset()
+	if err := app.Run("remote"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	out := buf.String()
+	for _, want := range []string{"remote <command>", "  add     Add a remote\n", "  branch\n", "    set\n", "  remove\n"} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("group help missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "status") {
+		t.Fatalf("group help lists commands outside the group:\n%s", out)
+	}
+
+	buf.Reset()
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "Commands:\n" +
+		"  remote\n" +
+		"    add     Add a remote\n" +
+		"    branch\n" +
+		"      set\n" +
+		"    remove\n" +
+		"  status    Show status\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("unexpected help:\n%s", buf.String())
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, CaseInsensitive: true})
+	var got []string
+	app.Add("status", func() { got = append(got, "status") })
+	app.Add("remote add", func(name string) { got = append(got, "remote add "+name) })
+	app.Add("remote remove", func(name string) {})
+	app.Alias("st", "status")
+
+	for _, args := range [][]string{{"Status"}, {"STATUS"}, {"ST"}, {"Remote", "ADD", "Origin"}} {
+		if err := app.Run(args...); err != nil {
+			t.Fatalf("Run(%q) failed: %v", args, err)
+		}
+	}
+	want := []string{"status", "status", "status", "remote add Origin"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("unexpected calls: %q", got)
+	}
+
+	if err := app.Run("REMOTE"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "  add") || !strings.Contains(buf.String(), "  remove") {
+		t.Fatalf("expected group help, got:\n%s", buf.String())
+	}
+
+	app = New(Options{ExitOnError: false, Log: &buf})
+	app.Add("status", func() {})
+	if err := app.Run("Status"); err == nil {
+		t.Fatalf("expected unknown command without CaseInsensitive")
+	}
+}
@@ -0,0 +1,122 @@
+package cliapp
+
+import (
+	"io"
+	"sort"
+	"strconv"
+)
+
+// The data passed to Options.HelpTemplate.
+//
+// Command is nil when rendering the application help (e.g. `prog --help`),
+// and describes the command when rendering per-command help.
+type HelpData struct {
+	Program  string
+	Version  string
+	Commands []HelpCommand
+	Command  *HelpCommand
+}
+
+// Describes a command in HelpData and HelpJSON.
+type HelpCommand struct {
+	Name    string       `json:"name"`
+	Help    string       `json:"help,omitempty"`
+	Usage   string       `json:"usage,omitempty"`
+	Aliases []string     `json:"aliases,omitempty"`
+	Args    []HelpArg    `json:"args,omitempty"`
+	Options []HelpOption `json:"options,omitempty"`
+}
+
+// Describes a positional argument in HelpData and HelpJSON.
+type HelpArg struct {
+	Position int    `json:"position"`
+	Name     string `json:"name"`
+	Type     string `json:"type"` // type label such as <int>
+	Default  string `json:"default,omitempty"`
+	Optional bool   `json:"optional,omitempty"`
+}
+
+// Describes a named option in HelpData and HelpJSON. Hidden options are
+// left out.
+type HelpOption struct {
+	Long       string   `json:"long"`
+	Short      string   `json:"short,omitempty"`
+	Negated    string   `json:"negated,omitempty"` // --no- form of bool options
+	Type       string   `json:"type,omitempty"`    // type label such as <string>; empty for flags
+	Help       string   `json:"help,omitempty"`
+	Default    string   `json:"default,omitempty"`
+	Env        string   `json:"env,omitempty"`
+	Choices    []string `json:"choices,omitempty"`
+	Required   bool     `json:"required,omitempty"`
+	Repeatable bool     `json:"repeatable,omitempty"`
+	Deprecated string   `json:"deprecated,omitempty"`
+}
+
+// Builds the template data for the application help, or for the command
+// name when h is not nil.
+func (a *App) helpData(name string, h *handler) HelpData {
+	d := HelpData{Program: a.programName(), Version: a.opts.Version}
+	names := make([]string, 0, len(a.cmds))
+	for cname, ch := range a.cmds {
+		if ch.alias == "" && !ch.hidden {
+			names = append(names, cname)
+		}
+	}
+	sort.Strings(names)
+	for _, cname := range names {
+		ch := a.cmds[cname]
+		d.Commands = append(d.Commands, HelpCommand{Name: cname, Help: ch.help, Aliases: a.aliasesOf(cname)})
+	}
+	if h == nil {
+		return d
+	}
+
+	cmdName := name
+	if cmdName == "" {
+		cmdName = a.programName()
+	}
+	c := HelpCommand{Name: name, Help: h.help, Usage: a.synopsis(cmdName, *h), Aliases: a.aliasesOf(name)}
+	if ps := handlerPositionals(*h); len(ps) > 0 {
+		for _, p := range ps {
+			c.Args = append(c.Args, HelpArg{Position: p.pos, Name: p.name, Type: p.typeLabel, Default: p.def, Optional: p.optional})
+		}
+	} else {
+		for i, t := range h.targs {
+			if _, ok := structParam(t); ok {
+				continue
+			}
+			c.Args = append(c.Args, HelpArg{Position: i, Name: "arg" + strconv.Itoa(i), Type: h.argLabel(i), Optional: h.isVariadicArg(i) || h.isOptionalArg(i)})
+		}
+	}
+	for _, o := range a.handlerOptions(*h) {
+		if o.hidden {
+			continue
+		}
+		ho := HelpOption{
+			Long:       o.long,
+			Short:      o.short,
+			Negated:    o.negated,
+			Help:       o.help,
+			Default:    o.def,
+			Env:        o.env,
+			Choices:    o.choices,
+			Required:   o.required,
+			Repeatable: o.repeatable || o.count,
+			Deprecated: o.deprecated,
+		}
+		if !o.isFlag {
+			ho.Type = o.typeLabel
+		}
+		c.Options = append(c.Options, ho)
+	}
+	d.Command = &c
+	return d
+}
+
+// Renders Options.HelpTemplate to w. Execution errors are written to
+// LogError.
+func (a *App) executeHelpTemplate(w io.Writer, name string, h *handler) {
+	if err := a.opts.HelpTemplate.Execute(w, a.helpData(name, h)); err != nil {
+		writeError(a.opts.LogError, a.opts.ErrorFormat, err, a.colorEnabled(a.opts.LogError))
+	}
+}
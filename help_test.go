@@ -0,0 +1,91 @@
+package cliapp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestHelpTemplate(t *testing.T) {
+	type DeployArgs struct {
+		Env     string `arg:"0" help:"environment"`
+		Replica int    `short:"-r" help:"replica count" default:"1"`
+		Secret  string `hidden:""`
+	}
+
+	tmpl := template.Must(template.New("help").Parse(
+		`{{if .Command}}{{.Command.Name}}: {{.Command.Help}}
+usage: {{.Command.Usage}}
+{{range .Command.Args}}arg {{.Position}} {{.Name}}
+{{end}}{{range .Command.Options}}opt {{.Short}} {{.Long}} {{.Type}} default={{.Default}}
+{{end}}{{else}}{{.Program}} {{.Version}}
+{{range .Commands}}* {{.Name}} - {{.Help}}
+{{end}}{{end}}`))
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, HelpTemplate: tmpl, Version: "1.0"})
+	app.Add("deploy", "Deploy the app", func(a DeployArgs) {})
+	app.Add("status", "Show status", func() {})
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := app.programName() + " 1.0\n* deploy - Deploy the app\n* status - Show status\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected app help:\n%q\nwant:\n%q", buf.String(), want)
+	}
+
+	buf.Reset()
+	if err := app.Run("deploy", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want = "deploy: Deploy the app\n" +
+		"usage: deploy <env> [--replica <int>]\n" +
+		"arg 0 environment\n" +
+		"opt -r --replica <int> default=1\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected command help:\n%q\nwant:\n%q", buf.String(), want)
+	}
+}
+
+func TestHelpTemplateError(t *testing.T) {
+	var out, errBuf bytes.Buffer
+	tmpl := template.Must(template.New("help").Parse(`{{.Missing}}`))
+	app := New(Options{ExitOnError: false, Log: &out, LogError: &errBuf, HelpTemplate: tmpl})
+	app.Add("x", func() {})
+
+	if err := app.Run("--help"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if errBuf.Len() == 0 {
+		t.Fatalf("expected template error to be written to LogError")
+	}
+}
+
+func TestHelpAlignment(t *testing.T) {
+	type SyncArgs struct {
+		Force     bool   `short:"-f" help:"overwrite files"`
+		Exclude   string `long:"--exclude-pattern" help:"skip files matching the pattern and everything below matching directories"`
+		Verbosity int    `short:"-v" count:"" help:"more output"`
+	}
+
+	var buf bytes.Buffer
+	app := New(Options{ExitOnError: false, Log: &buf, HelpWidth: 60})
+	app.Add("sync", func(a SyncArgs) {})
+
+	if err := app.Run("sync", "-h"); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := "Options:\n" +
+		"  -h|--help                     Show this help\n" +
+		"  -f|--force|--no-force         overwrite files\n" +
+		"  --exclude-pattern <string>    skip files matching the\n" +
+		"                                pattern and everything below\n" +
+		"                                matching directories\n" +
+		"  -v|--verbosity                more output (repeatable,\n" +
+		"                                counts occurrences)\n"
+	if !strings.HasSuffix(buf.String(), want) {
+		t.Fatalf("unexpected help:\n%s\nwant suffix:\n%s", buf.String(), want)
+	}
+}